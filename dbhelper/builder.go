@@ -0,0 +1,436 @@
+package dbhelper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// SelectBuilder 构造SELECT语句，sql片段使用?作为占位符，参数与片段一起传入，Build时按照方言编号。
+	SelectBuilder struct {
+		dialect DbDialect
+		columns []string
+		from    string
+		joins   []fragment
+		where   []fragment
+		groupBy []string
+		having  []fragment
+		orderBy []string
+		offset  int
+		limit   int
+	}
+
+	// UpdateBuilder 构造UPDATE语句。
+	UpdateBuilder struct {
+		dialect DbDialect
+		table   string
+		sets    []fragment
+		where   []fragment
+		lock    *optimisticLock
+	}
+
+	// DeleteBuilder 构造DELETE语句。
+	DeleteBuilder struct {
+		dialect DbDialect
+		table   string
+		where   []fragment
+	}
+
+	// WhereBuilder 构造WHERE或者HAVING子句的条件，End返回所属的构造器。
+	WhereBuilder[B any] struct {
+		owner B
+		parts *[]fragment
+	}
+
+	// fragment 表示一个sql片段及其参数。
+	fragment struct {
+		joint  string
+		column string // 不为空时表示column = ?，在Build时按照方言加上引号。
+		sql    string
+		args   []any
+	}
+)
+
+// ErrNoSetClause 表示UPDATE语句没有设置任何列。
+var ErrNoSetClause = errors.New("update has no SET clause")
+
+var (
+	identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	orPattern    = regexp.MustCompile(`(?i)\bOR\b`)
+)
+
+// NewSelectBuilder 创建SELECT语句的构造器，columns为空时查询全部列。方言默认为默认数据源的方言。
+func NewSelectBuilder(columns ...string) *SelectBuilder {
+	return &SelectBuilder{dialect: GetDialect(), columns: columns}
+}
+
+// NewUpdateBuilder 创建UPDATE语句的构造器。方言默认为默认数据源的方言。
+func NewUpdateBuilder(table string) *UpdateBuilder {
+	return &UpdateBuilder{dialect: GetDialect(), table: table}
+}
+
+// NewDeleteBuilder 创建DELETE语句的构造器。方言默认为默认数据源的方言。
+func NewDeleteBuilder(table string) *DeleteBuilder {
+	return &DeleteBuilder{dialect: GetDialect(), table: table}
+}
+
+// WithDialect 设置构造sql时使用的方言。
+func (b *SelectBuilder) WithDialect(d DbDialect) *SelectBuilder {
+	b.dialect = d
+	return b
+}
+
+// From 设置查询的表，可以带有别名，例如"user u"。
+func (b *SelectBuilder) From(table string) *SelectBuilder {
+	b.from = table
+	return b
+}
+
+// Join 添加连接子句，例如Join("LEFT JOIN role r ON r.id = u.role_id AND r.status = ?", 1)。
+func (b *SelectBuilder) Join(sql string, args ...any) *SelectBuilder {
+	b.joins = append(b.joins, fragment{sql: sql, args: args})
+	return b
+}
+
+// Where 开始构造WHERE子句。
+func (b *SelectBuilder) Where() *WhereBuilder[*SelectBuilder] {
+	return &WhereBuilder[*SelectBuilder]{owner: b, parts: &b.where}
+}
+
+// GroupBy 设置分组的列。
+func (b *SelectBuilder) GroupBy(columns ...string) *SelectBuilder {
+	b.groupBy = append(b.groupBy, columns...)
+	return b
+}
+
+// Having 开始构造HAVING子句。
+func (b *SelectBuilder) Having() *WhereBuilder[*SelectBuilder] {
+	return &WhereBuilder[*SelectBuilder]{owner: b, parts: &b.having}
+}
+
+// OrderBy 添加排序的列，例如OrderBy("create_time DESC", "id")。
+func (b *SelectBuilder) OrderBy(columns ...string) *SelectBuilder {
+	b.orderBy = append(b.orderBy, columns...)
+	return b
+}
+
+// Limit 设置分页参数，与SqlBuilder.Limit相同。
+// startRowIndex 起始行索引，从0开始。
+// maximumRows 最大行数，如果小于等于0，则不添加LIMIT子句。
+func (b *SelectBuilder) Limit(startRowIndex, maximumRows int) *SelectBuilder {
+	b.offset, b.limit = startRowIndex, maximumRows
+	return b
+}
+
+// Build 生成sql和按照占位符顺序排列的参数。表注册了作用域时返回ErrScopeNotApplied，此时应当使用BuildContext。
+func (b *SelectBuilder) Build() (string, []any, error) {
+	if err := checkUnscoped(b.from); err != nil {
+		return "", nil, err
+	}
+	return b.build()
+}
+
+func (b *SelectBuilder) build() (string, []any, error) {
+	w := &sqlWriter{dialect: b.dialect}
+
+	columns := "*"
+	if len(b.columns) > 0 {
+		columns = b.dialect.quoteColumns(b.columns)
+	}
+	w.writeString("SELECT " + columns)
+	if b.from != "" {
+		w.writeString("\nFROM " + b.dialect.quoteIfIdent(b.from))
+	}
+	for _, j := range b.joins {
+		w.writeString("\n")
+		w.write(j.sql, j.args)
+	}
+	w.writeConditions("\nWHERE\n  ", b.where)
+	if len(b.groupBy) > 0 {
+		w.writeString("\nGROUP BY " + b.dialect.quoteColumns(b.groupBy))
+	}
+	w.writeConditions("\nHAVING\n  ", b.having)
+	if len(b.orderBy) > 0 {
+		w.writeString("\nORDER BY " + b.dialect.quoteColumns(b.orderBy))
+	}
+	if b.limit > 0 {
+		w.writeString("\nLIMIT " + strconv.Itoa(b.limit))
+		if b.offset > 0 {
+			w.writeString(" OFFSET " + strconv.Itoa(b.offset))
+		}
+	}
+	return w.result()
+}
+
+// WithDialect 设置构造sql时使用的方言。
+func (b *UpdateBuilder) WithDialect(d DbDialect) *UpdateBuilder {
+	b.dialect = d
+	return b
+}
+
+// Set 设置列的值。
+func (b *UpdateBuilder) Set(column string, value any) *UpdateBuilder {
+	b.sets = append(b.sets, fragment{column: column, args: []any{value}})
+	return b
+}
+
+// SetIf 在p为true时设置列的值。
+func (b *UpdateBuilder) SetIf(p bool, column string, value any) *UpdateBuilder {
+	if p {
+		return b.Set(column, value)
+	}
+	return b
+}
+
+// SetExpr 使用表达式设置列的值，例如SetExpr("count = count + ?", 1)。
+func (b *UpdateBuilder) SetExpr(sql string, args ...any) *UpdateBuilder {
+	b.sets = append(b.sets, fragment{sql: sql, args: args})
+	return b
+}
+
+// Where 开始构造WHERE子句。
+func (b *UpdateBuilder) Where() *WhereBuilder[*UpdateBuilder] {
+	return &WhereBuilder[*UpdateBuilder]{owner: b, parts: &b.where}
+}
+
+// Build 生成sql和按照占位符顺序排列的参数。表注册了作用域时返回ErrScopeNotApplied，此时应当使用BuildContext。
+func (b *UpdateBuilder) Build() (string, []any, error) {
+	if err := checkUnscoped(b.table); err != nil {
+		return "", nil, err
+	}
+	return b.build()
+}
+
+func (b *UpdateBuilder) build() (string, []any, error) {
+	w := &sqlWriter{dialect: b.dialect}
+
+	sets, where := b.lockFragments()
+	if len(sets) == 0 {
+		return "", nil, ErrNoSetClause
+	}
+
+	w.writeString("UPDATE " + b.dialect.quoteIfIdent(b.table) + "\nSET\n  ")
+	for i, s := range sets {
+		if i > 0 {
+			w.writeString(",\n  ")
+		}
+		if s.column != "" {
+			w.write(b.dialect.QuoteIdent(s.column)+" = ?", s.args)
+		} else {
+			w.write(s.sql, s.args)
+		}
+	}
+	w.writeConditions("\nWHERE\n  ", where)
+	return w.result()
+}
+
+// WithDialect 设置构造sql时使用的方言。
+func (b *DeleteBuilder) WithDialect(d DbDialect) *DeleteBuilder {
+	b.dialect = d
+	return b
+}
+
+// Where 开始构造WHERE子句。
+func (b *DeleteBuilder) Where() *WhereBuilder[*DeleteBuilder] {
+	return &WhereBuilder[*DeleteBuilder]{owner: b, parts: &b.where}
+}
+
+// Build 生成sql和按照占位符顺序排列的参数。表注册了作用域时返回ErrScopeNotApplied，此时应当使用BuildContext。
+func (b *DeleteBuilder) Build() (string, []any, error) {
+	if err := checkUnscoped(b.table); err != nil {
+		return "", nil, err
+	}
+	return b.build()
+}
+
+func (b *DeleteBuilder) build() (string, []any, error) {
+	w := &sqlWriter{dialect: b.dialect}
+
+	w.writeString("DELETE FROM " + b.dialect.quoteIfIdent(b.table))
+	w.writeConditions("\nWHERE\n  ", b.where)
+	return w.result()
+}
+
+// And 添加使用AND连接的条件，例如And("status = ?", 1)。
+func (w *WhereBuilder[B]) And(sql string, args ...any) *WhereBuilder[B] {
+	*w.parts = append(*w.parts, fragment{joint: "AND", sql: sql, args: args})
+	return w
+}
+
+// AndIf 在p为true时添加使用AND连接的条件，例如AndIf(name != "", "name LIKE ?", "%"+name+"%")。
+func (w *WhereBuilder[B]) AndIf(p bool, sql string, args ...any) *WhereBuilder[B] {
+	if p {
+		return w.And(sql, args...)
+	}
+	return w
+}
+
+// Or 添加使用OR连接的条件，按照sql的优先级，a AND b OR c等价于(a AND b) OR c。
+func (w *WhereBuilder[B]) Or(sql string, args ...any) *WhereBuilder[B] {
+	*w.parts = append(*w.parts, fragment{joint: "OR", sql: sql, args: args})
+	return w
+}
+
+// OrIf 在p为true时添加使用OR连接的条件。
+func (w *WhereBuilder[B]) OrIf(p bool, sql string, args ...any) *WhereBuilder[B] {
+	if p {
+		return w.Or(sql, args...)
+	}
+	return w
+}
+
+// End 结束条件的构造，返回所属的构造器。
+func (w *WhereBuilder[B]) End() B {
+	return w.owner
+}
+
+// sqlWriter 拼接sql片段，将?占位符改写为方言对应的占位符并收集参数。
+type sqlWriter struct {
+	dialect DbDialect
+	sb      strings.Builder
+	args    []any
+	err     error
+}
+
+func (w *sqlWriter) writeString(s string) {
+	w.sb.WriteString(s)
+}
+
+// write 写入sql片段，片段中?的个数必须与参数个数相同。切片参数按照rewriteSql的规则展开。
+// PostgreSQL的?|和?&运算符不是占位符。
+func (w *sqlWriter) write(sql string, args []any) {
+	if w.err != nil {
+		return
+	}
+
+	n, last := 0, 0
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(sql, i, w.dialect == DialectMySQL && c != '`')
+		case c == '?' && (i+1 >= len(sql) || (sql[i+1] != '|' && sql[i+1] != '&')):
+			if n >= len(args) {
+				w.err = fmt.Errorf("%w: %q", ErrMissingArg{Index: n + 1}, sql)
+				return
+			}
+			w.sb.WriteString(sql[last:i])
+			last = i + 1
+			w.bind(args[n])
+			n++
+		}
+	}
+	w.sb.WriteString(sql[last:])
+
+	if n != len(args) {
+		w.err = fmt.Errorf("%w: %d placeholders but %d args in %q", ErrIllegalPlaceholder, n, len(args), sql)
+	}
+}
+
+// bind 写入一个参数的占位符，切片参数展开为多个占位符，空切片改写为不返回任何记录的子查询。
+func (w *sqlWriter) bind(v any) {
+	sv, ok := sliceArg(v)
+	if !ok {
+		w.args = append(w.args, v)
+		w.sb.WriteString(w.placeholder())
+	} else if sv.Len() == 0 {
+		w.sb.WriteString(w.dialect.emptySubquery())
+	} else {
+		for i := 0; i < sv.Len(); i++ {
+			if i > 0 {
+				w.sb.WriteByte(',')
+			}
+			w.args = append(w.args, sv.Index(i).Interface())
+			w.sb.WriteString(w.placeholder())
+		}
+	}
+}
+
+// placeholder 返回最后一个参数对应的占位符。
+func (w *sqlWriter) placeholder() string {
+	if w.dialect == DialectPostgres {
+		return "$" + strconv.Itoa(len(w.args))
+	}
+	return "?"
+}
+
+// writeConditions 写入条件，prefix是WHERE或者HAVING，没有条件时什么也不写。
+func (w *sqlWriter) writeConditions(prefix string, parts []fragment) {
+	if len(parts) == 0 {
+		return
+	}
+
+	w.writeString(prefix)
+	for i, p := range parts {
+		if i > 0 {
+			w.writeString("\n  " + p.joint + " ")
+		}
+		if len(parts) > 1 && orPattern.MatchString(p.sql) {
+			w.writeString("(")
+			w.write(p.sql, p.args)
+			w.writeString(")")
+		} else {
+			w.write(p.sql, p.args)
+		}
+	}
+}
+
+// andConditions 在条件之后追加使用AND连接的条件，原有的条件包含OR时作为一个整体参与运算。
+func andConditions(parts []fragment, extra ...fragment) []fragment {
+	if len(extra) == 0 {
+		return parts
+	}
+	for _, p := range parts[min(len(parts), 1):] {
+		if p.joint == "OR" {
+			parts = []fragment{groupConditions(parts)}
+			break
+		}
+	}
+	return append(parts[:len(parts):len(parts)], extra...)
+}
+
+// groupConditions 将多个条件合并为一个片段，合并前后的运算优先级相同。
+func groupConditions(parts []fragment) fragment {
+	var sb strings.Builder
+	var args []any
+	for i, p := range parts {
+		if i > 0 {
+			sb.WriteString("\n  " + p.joint + " ")
+		}
+		if len(parts) > 1 && orPattern.MatchString(p.sql) {
+			sb.WriteString("(" + p.sql + ")")
+		} else {
+			sb.WriteString(p.sql)
+		}
+		args = append(args, p.args...)
+	}
+	return fragment{joint: "AND", sql: sb.String(), args: args}
+}
+
+func (w *sqlWriter) result() (string, []any, error) {
+	if w.err != nil {
+		return "", nil, w.err
+	} else if limit := w.dialect.maxPlaceholders(); len(w.args) > limit {
+		return "", nil, fmt.Errorf("%w: %d args, at most %d", ErrTooManyArgs, len(w.args), limit)
+	}
+	return w.sb.String(), w.args, nil
+}
+
+// quoteIfIdent 为简单的标识符加上引号，表达式和带有别名的表名保持不变。
+func (d DbDialect) quoteIfIdent(s string) string {
+	if identPattern.MatchString(s) {
+		return d.QuoteIdent(s)
+	}
+	return s
+}
+
+// quoteColumns 为列清单中的简单标识符加上引号并使用逗号连接。
+func (d DbDialect) quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = d.quoteIfIdent(col)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,303 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// BulkOptions 表示批量插入的选项。
+type BulkOptions struct {
+	ChunkSize       int  // 每条INSERT语句最多包含的行数，小于等于0时根据方言的参数个数上限自动计算。
+	ContinueOnError bool // 某些行插入失败时是否继续插入其它行，否则回滚全部记录。
+	UseCopy         bool // 在PostgreSQL上使用COPY插入，此时忽略ChunkSize和ContinueOnError。
+}
+
+// BulkResult 表示批量插入的结果。
+type BulkResult struct {
+	RowsAffected int64      // 成功插入的记录数。
+	Errors       []RowError // 插入失败的行，仅在ContinueOnError时可能非空。
+}
+
+// RowError 表示批量插入时某一行发生的错误。
+type RowError struct {
+	Index int   // 行的索引，从0开始。
+	Err   error // 插入该行时发生的错误。
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Index, e.Err)
+}
+
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// ErrColumnCount 表示批量插入的某一行的值的个数与列数不一致。
+var ErrColumnCount = errors.New("column count does not match value count")
+
+// InsertBatch 在一个事务中使用同一条sql逐行插入记录，如果上下文中已有事务则加入该事务。
+// 任何一行插入失败都会回滚全部记录并返回该错误；值为nil的行会被跳过。
+// 返回成功插入的记录数。
+func InsertBatch(ctx context.Context, query string, rows ...[]any) (int64, error) {
+	txCtx, err := BeginTxWithOptions(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer CloseTx(txCtx)
+
+	ds, cr, err := resolveDataSource(txCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	// 参数相同的行改写后的sql也相同，只需要准备一次。
+	stmts := make(map[string]*statement)
+	defer func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+	}()
+
+	c := int64(0)
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+
+		oquery, oargs, err := rewriteSql(ds.dialect, query, row)
+		if err != nil {
+			return 0, err
+		}
+		st, ok := stmts[oquery]
+		if !ok {
+			if st, err = prepareStmt(txCtx, ds, cr, nil, oquery, oargs); err != nil {
+				return 0, err
+			}
+			stmts[oquery] = st
+		} else {
+			st.start = time.Now()
+		}
+		st.args = oargs
+
+		// 每一行单独计算超时时间。
+		rowCtx, cancel := ds.queryContext(txCtx)
+		r, err := st.ExecContext(rowCtx, oargs...)
+		if err != nil {
+			err = classifyTimeout(rowCtx, err)
+		}
+		cancel()
+		if err != nil {
+			st.done(txCtx, -1, err)
+			return 0, err
+		} else if c_, err := r.RowsAffected(); err != nil {
+			err = ClassifyError(err)
+			st.done(txCtx, -1, err)
+			return 0, err
+		} else {
+			st.done(txCtx, c_, nil)
+			c += c_
+		}
+	}
+
+	if err := TryCommitTx(txCtx); err != nil {
+		return 0, err
+	}
+	return c, nil
+}
+
+// BulkInsert 使用多行VALUES的INSERT语句批量插入记录，如果上下文中已有事务则加入该事务。
+// table和columns会按照方言加上引号，rows中每一行的值的顺序必须与columns一致。
+// 记录按照ChunkSize分成多条sql执行，保证每条sql的参数个数不超过方言的上限。
+//
+// 默认任何一行插入失败都会回滚全部记录并返回该错误。
+// 如果指定了ContinueOnError，那么每条sql在独立的保存点中执行，失败时回滚到保存点并逐行重试以找出失败的行，
+// 失败的行记录在BulkResult.Errors中，其它行正常提交，此时返回的错误只表示事务本身失败。
+// 违反外键约束总是作为错误返回，不受ReportForeignKeyViolation影响。
+func BulkInsert(ctx context.Context, table string, columns []string, rows [][]any, opts *BulkOptions) (*BulkResult, error) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	result := &BulkResult{}
+	if len(rows) == 0 || len(columns) == 0 {
+		return result, nil
+	}
+
+	txCtx, err := BeginTxWithOptions(ReportForeignKeyViolation(ctx, true), nil)
+	if err != nil {
+		return result, err
+	}
+
+	defer CloseTx(txCtx)
+
+	d := DialectOf(txCtx)
+	if opts.UseCopy && d == DialectPostgres {
+		if err := copyIn(txCtx, table, columns, rows, result); err != nil {
+			return result, err
+		}
+	} else {
+		// 列数超过参数个数上限时一行也无法插入。
+		if len(columns) > d.maxPlaceholders() {
+			return result, fmt.Errorf("%w: %d columns, at most %d", ErrTooManyArgs, len(columns), d.maxPlaceholders())
+		}
+		chunkSize := d.maxPlaceholders() / len(columns)
+		if opts.ChunkSize > 0 && opts.ChunkSize < chunkSize {
+			chunkSize = opts.ChunkSize
+		}
+
+		for start := 0; start < len(rows); start += chunkSize {
+			end := min(start+chunkSize, len(rows))
+			if err := insertChunk(txCtx, d, table, columns, rows[start:end], start, opts.ContinueOnError, result); err != nil {
+				result.RowsAffected = 0
+				return result, err
+			}
+		}
+	}
+
+	if err := TryCommitTx(txCtx); err != nil {
+		result.RowsAffected = 0
+		return result, err
+	}
+	return result, nil
+}
+
+// insertChunk 使用一条sql插入一组记录，offset是第一行在全部记录中的索引。
+func insertChunk(ctx context.Context, d DbDialect, table string, columns []string, rows [][]any, offset int, continueOnError bool, result *BulkResult) error {
+	valid := make([][]any, 0, len(rows))
+	validIndex := make([]int, 0, len(rows))
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			if !continueOnError {
+				return RowError{Index: offset + i, Err: ErrColumnCount}
+			}
+			result.Errors = append(result.Errors, RowError{Index: offset + i, Err: ErrColumnCount})
+		} else {
+			valid = append(valid, row)
+			validIndex = append(validIndex, offset+i)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+
+	if !continueOnError {
+		n, err := execInsert(ctx, d, table, columns, valid)
+		result.RowsAffected += n
+		return err
+	}
+
+	if n, err := execInsertNested(ctx, d, table, columns, valid); err == nil {
+		result.RowsAffected += n
+		return nil
+	} else if KindOf(err) == KindConnectionLost || KindOf(err) == KindQueryCanceled || KindOf(err) == KindQueryTimeout || errors.Is(err, ErrTxNotAlive) {
+		return err
+	}
+
+	// 整组插入失败，逐行重试以找出失败的行。
+	for i, row := range valid {
+		if n, err := execInsertNested(ctx, d, table, columns, [][]any{row}); err != nil {
+			result.Errors = append(result.Errors, RowError{Index: validIndex[i], Err: err})
+		} else {
+			result.RowsAffected += n
+		}
+	}
+	return nil
+}
+
+// execInsertNested 在保存点中插入一组记录，失败时回滚到保存点，以便事务可以继续。
+func execInsertNested(ctx context.Context, d DbDialect, table string, columns []string, rows [][]any) (int64, error) {
+	spCtx, err := BeginTxWithOptions(ctx, &TxOptions{Propagation: PropagationNested})
+	if err != nil {
+		return 0, err
+	}
+
+	defer CloseTx(spCtx)
+
+	if n, err := execInsert(spCtx, d, table, columns, rows); err != nil {
+		return 0, err
+	} else {
+		return n, TryCommitTx(spCtx)
+	}
+}
+
+// execInsert 生成并执行多行VALUES的INSERT语句。
+func execInsert(ctx context.Context, d DbDialect, table string, columns []string, rows [][]any) (int64, error) {
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = d.QuoteIdent(col)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO " + d.QuoteIdent(table) + " (" + strings.Join(quoted, ",") + ") VALUES ")
+	args := make([]any, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('(')
+		for j := range row {
+			if j > 0 {
+				sb.WriteByte(',')
+			}
+			if d == DialectPostgres {
+				sb.WriteString("$" + strconv.Itoa(len(args)+j+1))
+			} else {
+				sb.WriteByte('?')
+			}
+		}
+		sb.WriteByte(')')
+		args = append(args, row...)
+	}
+
+	return Exec[int64](ctx, sb.String(), args...)
+}
+
+// copyIn 在PostgreSQL上使用COPY插入全部记录。
+func copyIn(ctx context.Context, table string, columns []string, rows [][]any, result *BulkResult) error {
+	ds, cr, err := resolveDataSource(ctx)
+	if err != nil {
+		return err
+	}
+
+	// COPY语句不能复用，不使用预编译语句缓存；COPY的耗时与记录数成正比，不设置超时时间。
+	st := &statement{ds: ds, inTx: true, query: pq.CopyIn(table, columns...), start: time.Now(), ctx: ctx, cancel: func() {}}
+	if st.Stmt, err = cr.tx.PrepareContext(ctx, st.query); err != nil {
+		err = ClassifyError(err)
+		st.done(ctx, -1, err)
+		return err
+	}
+
+	defer st.Close()
+
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			err = RowError{Index: i, Err: ErrColumnCount}
+		} else if _, err0 := st.ExecContext(ctx, row...); err0 != nil {
+			err = RowError{Index: i, Err: ClassifyError(err0)}
+		}
+		if err != nil {
+			st.done(ctx, int64(i), err)
+			return err
+		}
+	}
+
+	if r, err := st.ExecContext(ctx); err != nil {
+		err = ClassifyError(err)
+		st.done(ctx, -1, err)
+		return err
+	} else if n, err := r.RowsAffected(); err != nil {
+		err = ClassifyError(err)
+		st.done(ctx, -1, err)
+		return err
+	} else {
+		st.done(ctx, n, nil)
+		result.RowsAffected = n
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestInsertBatch(t *testing.T) {
+	ctx := context.TODO()
+
+	if c, err := InsertBatch(ctx, "INSERT INTO user (user_name, nick_name) VALUES (:1, :2)", []any{"batch1", "a"}, nil, []any{"batch2", "b"}); err != nil {
+		t.Fatal(err)
+	} else if c != 2 {
+		t.Errorf("InsertBatch => %v, want 2", c)
+	}
+	if c := countUser(t, ctx, "batch2"); c != 1 {
+		t.Errorf("count(batch2) => %v, want 1", c)
+	}
+
+	// 任何一行失败都回滚全部记录。
+	if _, err := InsertBatch(ctx, "INSERT INTO user (user_name) VALUES (:1)", []any{"batch3"}, []any{"batch1"}); !errors.Is(err, ErrUniqueViolation) {
+		t.Errorf("InsertBatch(duplicate) => %v, want ErrUniqueViolation", err)
+	}
+	if c := countUser(t, ctx, "batch3"); c != 0 {
+		t.Errorf("count(batch3) => %v, want 0", c)
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	ctx := context.TODO()
+
+	rows := make([][]any, 0, 25)
+	for i := 0; i < 25; i++ {
+		rows = append(rows, []any{"bulk" + string(rune('a'+i)), "bulk"})
+	}
+	if r, err := BulkInsert(ctx, "user", []string{"user_name", "nick_name"}, rows, &BulkOptions{ChunkSize: 10}); err != nil {
+		t.Fatal(err)
+	} else if r.RowsAffected != 25 {
+		t.Errorf("BulkInsert => %v, want 25", r.RowsAffected)
+	}
+
+	// 第2行重复、第3行列数错误，其它行正常插入。
+	rows = [][]any{{"bulk1", nil}, {"bulka", nil}, {"bulk2"}, {"bulk3", nil}}
+	if r, err := BulkInsert(ctx, "user", []string{"user_name", "nick_name"}, rows, &BulkOptions{ContinueOnError: true}); err != nil {
+		t.Fatal(err)
+	} else if r.RowsAffected != 2 || len(r.Errors) != 2 {
+		t.Errorf("BulkInsert(continue) => %v, %v, want 2, 2 errors", r.RowsAffected, r.Errors)
+	} else if r.Errors[0].Index != 2 || !errors.Is(r.Errors[0], ErrColumnCount) || r.Errors[1].Index != 1 || !errors.Is(r.Errors[1], ErrUniqueViolation) {
+		t.Errorf("BulkInsert(continue).Errors => %v", r.Errors)
+	}
+	if c := countUser(t, ctx, "bulk3"); c != 1 {
+		t.Errorf("count(bulk3) => %v, want 1", c)
+	}
+
+	if r, err := BulkInsert(ctx, "user", []string{"user_name"}, [][]any{{"bulk4"}, {"bulka"}}, nil); !errors.Is(err, ErrUniqueViolation) || r.RowsAffected != 0 {
+		t.Errorf("BulkInsert(duplicate) => %v, %v, want ErrUniqueViolation", r.RowsAffected, err)
+	}
+	if c := countUser(t, ctx, "bulk4"); c != 0 {
+		t.Errorf("count(bulk4) => %v, want 0", c)
+	}
+
+	columns := make([]string, DialectSQLite.maxPlaceholders()+1)
+	if _, err := BulkInsert(ctx, "user", columns, [][]any{make([]any, len(columns))}, nil); !errors.Is(err, ErrTooManyArgs) {
+		t.Errorf("BulkInsert(too many columns) => %v, want ErrTooManyArgs", err)
+	}
+}
@@ -0,0 +1,118 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Cursor 逐条读取查询结果，适用于无法一次性装入内存的大结果集。
+//
+// go-sql-driver/mysql、lib/pq和go-sqlite3都在调用Next时才从连接中读取下一条记录，不会缓存整个结果集，
+// 所以内存占用只与单条记录的大小有关。游标打开期间会独占一个连接，在事务中使用时，
+// 必须先关闭游标再在同一个事务中执行其它sql（MySQL的协议不允许在一个连接上交错读取多个结果集）。
+//
+// 使用完毕后必须调用Close，提前结束遍历时也是如此：
+//
+//	c, err := QueryCursor[User](ctx, "SELECT * FROM user", StructMapper[User]{})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	for c.Next() {
+//		u := c.Item()
+//	}
+//	return c.Err()
+type Cursor[T any] struct {
+	ctx    context.Context
+	st     *statement
+	rows   *sql.Rows
+	rh     RowHandler[T]
+	item   *T
+	n      int64
+	err    error
+	closed bool
+}
+
+// QueryCursor 执行查询并返回游标。
+// 游标的读取速度取决于调用者，因此不使用数据源默认的超时时间，只受上下文本身的截止时间约束；
+// WithQueryTimeout指定的超时时间仍然有效，从打开游标开始计算，直到关闭游标。
+// 在PostgreSQL上，WithStatementTimeout设置的服务端statement_timeout仍然限制查询的执行时间，参见WithStatementTimeout。
+func QueryCursor[T any](ctx context.Context, query string, rh RowHandler[T], args ...any) (*Cursor[T], error) {
+	st, err := prepareQuery(withoutDefaultTimeout(ctx), query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if rows, err := st.QueryContext(st.ctx, st.args...); err != nil {
+		err = st.classify(err)
+		st.done(ctx, -1, err)
+		st.Close()
+		return nil, err
+	} else {
+		return &Cursor[T]{ctx: ctx, st: st, rows: rows, rh: rh}, nil
+	}
+}
+
+// Next 读取下一条记录，没有更多记录或者发生错误时返回false并关闭游标。
+func (c *Cursor[T]) Next() bool {
+	if c.closed {
+		return false
+	}
+
+	if !c.rows.Next() {
+		c.err = c.st.classify(c.rows.Err())
+		c.Close()
+		return false
+	} else if item, err := c.rh.Scan(c.rows); err != nil {
+		c.err = c.st.classify(err)
+		c.Close()
+		return false
+	} else {
+		c.item = item
+		c.n++
+		return true
+	}
+}
+
+// Item 获取当前记录。
+func (c *Cursor[T]) Item() *T {
+	return c.item
+}
+
+// Err 获取遍历过程中发生的错误。
+func (c *Cursor[T]) Err() error {
+	return c.err
+}
+
+// Close 关闭游标，释放结果集、语句和连接，可以重复调用。
+// 关闭时报告sql的执行日志，耗时包括遍历结果集的时间。
+func (c *Cursor[T]) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	err := c.rows.Close()
+	if err0 := c.st.Close(); err == nil {
+		err = err0
+	}
+	c.st.done(c.ctx, c.n, c.err)
+	return err
+}
+
+// QueryEach 执行查询并对每条记录调用fn，fn返回错误时停止遍历并返回该错误。超时时间与QueryCursor相同，包括PostgreSQL上的限制。
+func QueryEach[T any](ctx context.Context, query string, rh RowHandler[T], fn func(item *T) error, args ...any) error {
+	c, err := QueryCursor[T](ctx, query, rh, args...)
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	for c.Next() {
+		if err := fn(c.Item()); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
@@ -0,0 +1,43 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	ctx := context.TODO()
+
+	for _, n := range []string{"cursor1", "cursor2", "cursor3"} {
+		MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", n)
+	}
+
+	c, err := QueryCursor[userPo](ctx, "SELECT id, user_name FROM user WHERE user_name LIKE :1 ORDER BY id", StructMapper[userPo]{}, "cursor%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for c.Next() {
+		names = append(names, c.Item().UserName)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	} else if len(names) != 3 || names[2] != "cursor3" {
+		t.Errorf("Cursor => %v", names)
+	}
+	c.Close()
+
+	// 提前结束遍历时也会释放连接，否则内存数据库的唯一连接会被占用。
+	stop := errors.New("stop")
+	count := 0
+	if err := QueryEach[userPo](ctx, "SELECT id, user_name FROM user WHERE user_name LIKE :1", StructMapper[userPo]{}, func(u *userPo) error {
+		count++
+		return stop
+	}, "cursor%"); err != stop || count != 1 {
+		t.Errorf("QueryEach => %v, %v, want stop, 1", err, count)
+	}
+	if c := countUser(t, ctx, "cursor1"); c != 1 {
+		t.Errorf("count(cursor1) => %v, want 1", c)
+	}
+}
@@ -0,0 +1,150 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDataSource 默认数据源的名称，InitMySqlDb、InitPostgresDb等函数初始化的都是该数据源。
+const DefaultDataSource = "default"
+
+// DataSource 表示一个命名的数据源，每个数据源都有自己的连接池和方言。
+// 数据源可以有若干从库，事务之外的只读查询在从库上执行，参见AddReplica。
+type DataSource struct {
+	name        string
+	db          *sql.DB
+	dialect     DbDialect
+	reportFK    bool
+	stmtTimeout time.Duration // 默认的sql超时时间，小于等于0表示不设置。
+	stmts       *stmtCache
+
+	replicaLock   sync.Mutex
+	replicas      atomic.Pointer[[]*replica]
+	replicaPolicy ReplicaPolicy
+	replicaNext   atomic.Uint64
+	stopCheck     chan struct{}
+}
+
+// dsCtxKey 用于在上下文中记录数据源名称的key。
+type dsCtxKey struct{}
+
+// fkCtxKey 用于在上下文中记录是否报告违反外键约束的key。
+type fkCtxKey struct{}
+
+var (
+	dsLock      sync.RWMutex
+	dataSources = make(map[string]*DataSource)
+)
+
+// Name 获取数据源的名称。
+func (ds *DataSource) Name() string { return ds.name }
+
+// DB 获取数据源的连接池。
+func (ds *DataSource) DB() *sql.DB { return ds.db }
+
+// Dialect 获取数据源的方言。
+func (ds *DataSource) Dialect() DbDialect { return ds.dialect }
+
+// SetReportForeignKeyViolation 设置执行sql违反外键约束时是否返回错误。
+// 默认为false，即Exec和ExecLastInsertId忽略该错误并返回0，可以通过ReportForeignKeyViolation针对单次调用修改。
+// 应当在初始化数据源时设置。
+func (ds *DataSource) SetReportForeignKeyViolation(report bool) { ds.reportFK = report }
+
+// RegisterDataSource 注册一个数据源，如果已存在同名的数据源则替换之。
+// 被替换的数据源的连接池不会被关闭，由调用者负责。
+func RegisterDataSource(name string, db *sql.DB, dialect DbDialect) *DataSource {
+	ds := &DataSource{name: name, db: db, dialect: dialect, stmts: newStmtCache(DefaultStmtCacheSize)}
+
+	dsLock.Lock()
+	defer dsLock.Unlock()
+
+	dataSources[name] = ds
+	return ds
+}
+
+// GetDataSource 获取指定名称的数据源，如果不存在则返回nil。
+func GetDataSource(name string) *DataSource {
+	dsLock.RLock()
+	defer dsLock.RUnlock()
+
+	return dataSources[name]
+}
+
+// CloseDataSource 注销指定名称的数据源并关闭其连接池，包括所有从库的连接池。
+func CloseDataSource(name string) error {
+	dsLock.Lock()
+	ds, ok := dataSources[name]
+	delete(dataSources, name)
+	dsLock.Unlock()
+
+	if ok {
+		err := ds.closeReplicas()
+		ds.stmts.resize(0)
+		return errors.Join(ds.db.Close(), err)
+	} else {
+		return nil
+	}
+}
+
+// WithDataSource 返回一个新的上下文，之后使用该上下文执行的sql都会使用指定名称的数据源。
+// 如果上下文中已经存在其它数据源的事务，那么该事务对新上下文不可见。
+func WithDataSource(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, dsCtxKey{}, name)
+}
+
+// ReportForeignKeyViolation 返回一个新的上下文，之后使用该上下文执行sql违反外键约束时是否返回错误，覆盖数据源的设置。
+func ReportForeignKeyViolation(ctx context.Context, report bool) context.Context {
+	return context.WithValue(ctx, fkCtxKey{}, report)
+}
+
+// swallowForeignKeyViolation 判断是否应当忽略违反外键约束的错误。
+func swallowForeignKeyViolation(ctx context.Context, err error) bool {
+	if !isForeignKeyViolation(err) {
+		return false
+	} else if report, ok := ctx.Value(fkCtxKey{}).(bool); ok {
+		return !report
+	} else if ds, _ := resolve(ctx); ds != nil {
+		return !ds.reportFK
+	}
+	return true
+}
+
+// DialectOf 获取上下文对应的数据源的方言。
+func DialectOf(ctx context.Context) DbDialect {
+	if ds, _ := resolve(ctx); ds != nil {
+		return ds.dialect
+	}
+	return DialectMySQL
+}
+
+// dataSourceName 获取上下文中指定的数据源名称，未指定时返回默认数据源的名称。
+func dataSourceName(ctx context.Context) string {
+	if name, ok := ctx.Value(dsCtxKey{}).(string); ok && name != "" {
+		return name
+	}
+	return DefaultDataSource
+}
+
+// resolve 获取上下文对应的数据源和事务。
+// 如果上下文中的事务属于上下文指定的数据源，那么返回该事务，否则返回的事务为nil。
+func resolve(ctx context.Context) (*DataSource, *ctxRef) {
+	name := dataSourceName(ctx)
+	if cr, ok := ctx.Value(ctxKey{}).(*ctxRef); ok && cr.ds.name == name {
+		return cr.ds, cr
+	}
+	return GetDataSource(name), nil
+}
+
+// resolveDataSource 获取上下文对应的数据源和事务，如果数据源不存在则返回错误。
+func resolveDataSource(ctx context.Context) (*DataSource, *ctxRef, error) {
+	ds, cr := resolve(ctx)
+	if ds == nil {
+		return nil, nil, fmt.Errorf("%w: %q", ErrDataSourceNotFound, dataSourceName(ctx))
+	}
+	return ds, cr, nil
+}
@@ -0,0 +1,37 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestResolveDataSource(t *testing.T) {
+	db0, err := sql.Open("postgres", "host=localhost dbname=report sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterDataSource("report", db0, DialectPostgres)
+	defer CloseDataSource("report")
+
+	ctx := WithDataSource(context.TODO(), "report")
+	if ds, cr := resolve(ctx); ds == nil || ds.Name() != "report" || cr != nil {
+		t.Errorf("resolve(report) => %v, %v", ds, cr)
+	}
+	if d := DialectOf(ctx); d != DialectPostgres {
+		t.Errorf("DialectOf(report) => %v, want %v", d, DialectPostgres)
+	}
+
+	// 其它数据源的事务对当前数据源不可见。
+	txCtx := context.WithValue(ctx, ctxKey{}, &ctxRef{ds: GetDataSource("report"), alive: true})
+	if _, cr := resolve(txCtx); cr == nil {
+		t.Errorf("resolve(tx) => nil, want tx")
+	}
+	if _, cr := resolve(WithDataSource(txCtx, DefaultDataSource)); cr != nil {
+		t.Errorf("resolve(default) => %v, want nil", cr)
+	}
+
+	if ds := GetDataSource("missing"); ds != nil {
+		t.Errorf("GetDataSource(missing) => %v, want nil", ds)
+	}
+}
@@ -0,0 +1,328 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrTxNotAlive 表示上下文中的事务已经提交或者回滚。
+	ErrTxNotAlive = errors.New("current transaction is not alive")
+	// ErrIllegalPlaceholder 表示sql中存在非法的参数占位符。
+	ErrIllegalPlaceholder = errors.New("illegal sql placeholder")
+	// ErrTooManyArgs 表示展开切片后sql的参数个数超过了方言的上限。
+	ErrTooManyArgs = errors.New("too many sql args")
+	// ErrDataSourceNotFound 表示上下文对应的数据源尚未初始化。
+	ErrDataSourceNotFound = errors.New("data source is not initialized")
+)
+
+// ErrMissingArg 表示sql中的参数占位符没有对应的参数。
+type ErrMissingArg struct {
+	Index int    // 位置参数的序号，从1开始。
+	Name  string // 命名参数的名称。
+}
+
+func (e ErrMissingArg) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("no arg named %q", e.Name)
+	}
+	return fmt.Sprintf("no enough args, wants %d", e.Index)
+}
+
+// ErrorKind 表示与具体数据库无关的错误分类。
+type ErrorKind int
+
+const (
+	KindUnknown              ErrorKind = iota // 未知错误。
+	KindUniqueViolation                       // 违反唯一约束或者主键约束。
+	KindForeignKeyViolation                   // 违反外键约束。
+	KindNotNullViolation                      // 违反非空约束。
+	KindCheckViolation                        // 违反检查约束。
+	KindDeadlock                              // 发生死锁。
+	KindLockTimeout                           // 等待锁超时。
+	KindSerializationFailure                  // 序列化失败。
+	KindConnectionLost                        // 连接断开。
+	KindQueryCanceled                         // 查询被取消。
+	KindQueryTimeout                          // 查询超时，errors.Is对ErrQueryCanceled也返回true。
+)
+
+var (
+	ErrUniqueViolation      = errors.New("unique constraint violation")
+	ErrForeignKeyViolation  = errors.New("foreign key constraint violation")
+	ErrNotNullViolation     = errors.New("not null constraint violation")
+	ErrCheckViolation       = errors.New("check constraint violation")
+	ErrDeadlock             = errors.New("deadlock detected")
+	ErrLockTimeout          = errors.New("lock wait timeout")
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrConnectionLost       = errors.New("connection lost")
+	ErrQueryCanceled        = errors.New("query canceled")
+	ErrQueryTimeout         = errors.New("query timeout")
+
+	kindErrors = map[ErrorKind]error{
+		KindUniqueViolation:      ErrUniqueViolation,
+		KindForeignKeyViolation:  ErrForeignKeyViolation,
+		KindNotNullViolation:     ErrNotNullViolation,
+		KindCheckViolation:       ErrCheckViolation,
+		KindDeadlock:             ErrDeadlock,
+		KindLockTimeout:          ErrLockTimeout,
+		KindSerializationFailure: ErrSerializationFailure,
+		KindConnectionLost:       ErrConnectionLost,
+		KindQueryCanceled:        ErrQueryCanceled,
+		KindQueryTimeout:         ErrQueryTimeout,
+	}
+
+	mysqlKeyPattern        = regexp.MustCompile("for key '([^']+)'")
+	mysqlConstraintPattern = regexp.MustCompile("CONSTRAINT `([^`]+)`")
+	mysqlForeignKeyPattern = regexp.MustCompile("\\(`([^`]+)`\\.`([^`]+)`, CONSTRAINT `[^`]+` FOREIGN KEY \\(`([^`]+)`\\)")
+	mysqlColumnPattern     = regexp.MustCompile("(?:Column|Field) '([^']+)'")
+	mysqlCheckPattern      = regexp.MustCompile("Check constraint '([^']+)'")
+)
+
+// DbError 表示经过分类的数据库错误，可以使用errors.Is判断其分类，例如errors.Is(err, ErrUniqueViolation)。
+type DbError struct {
+	Kind       ErrorKind // 错误分类。
+	Code       string    // 驱动返回的原始错误码。
+	Table      string    // 相关的表名，驱动未提供时为空。
+	Column     string    // 相关的列名，驱动未提供时为空。
+	Constraint string    // 相关的约束名，驱动未提供时为空。
+	Err        error     // 驱动返回的原始错误。
+}
+
+func (e *DbError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DbError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DbError) Is(target error) bool {
+	if target == nil {
+		return false
+	} else if e.Kind == KindQueryTimeout && target == ErrQueryCanceled {
+		// 超时是取消的一种，兼容之前判断ErrQueryCanceled的代码。
+		return true
+	}
+	return kindErrors[e.Kind] == target
+}
+
+// KindOf 获取错误的分类。
+func KindOf(err error) ErrorKind {
+	var de *DbError
+	if errors.As(err, &de) {
+		return de.Kind
+	} else if de := classify(err); de != nil {
+		return de.Kind
+	}
+	return KindUnknown
+}
+
+// ClassifyError 将驱动返回的错误转换为*DbError，无法分类的错误原样返回。
+func ClassifyError(err error) error {
+	var de *DbError
+	if err == nil || errors.As(err, &de) {
+		return err
+	} else if de := classify(err); de != nil {
+		return de
+	} else {
+		return err
+	}
+}
+
+func classify(err error) *DbError {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return classifyMySQL(me)
+	}
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		return classifyPostgres(pe)
+	}
+	if se, code, extCode, ok := asSqliteError(err); ok {
+		return classifySqlite(se, code, extCode)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &DbError{Kind: KindQueryTimeout, Err: err}
+	} else if errors.Is(err, context.Canceled) {
+		return &DbError{Kind: KindQueryCanceled, Err: err}
+	}
+	var ne net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &ne) {
+		return &DbError{Kind: KindConnectionLost, Err: err}
+	}
+	return nil
+}
+
+func classifyMySQL(me *mysql.MySQLError) *DbError {
+	de := &DbError{Code: strconv.Itoa(int(me.Number)), Err: me}
+	switch me.Number {
+	case 1062, 1586:
+		de.Kind = KindUniqueViolation
+		if m := mysqlKeyPattern.FindStringSubmatch(me.Message); m != nil {
+			// MySQL 8.0之后的键名包含表名，例如`user.uk_user_name`。
+			if i := strings.LastIndexByte(m[1], '.'); i >= 0 {
+				de.Table, de.Constraint = m[1][:i], m[1][i+1:]
+			} else {
+				de.Constraint = m[1]
+			}
+		}
+	case 1216, 1217, 1451, 1452:
+		de.Kind = KindForeignKeyViolation
+		if m := mysqlConstraintPattern.FindStringSubmatch(me.Message); m != nil {
+			de.Constraint = m[1]
+		}
+		if m := mysqlForeignKeyPattern.FindStringSubmatch(me.Message); m != nil {
+			de.Table, de.Column = m[2], m[3]
+		}
+	case 1048, 1364:
+		de.Kind = KindNotNullViolation
+		if m := mysqlColumnPattern.FindStringSubmatch(me.Message); m != nil {
+			de.Column = m[1]
+		}
+	case 3819:
+		de.Kind = KindCheckViolation
+		if m := mysqlCheckPattern.FindStringSubmatch(me.Message); m != nil {
+			de.Constraint = m[1]
+		}
+	case 1213:
+		de.Kind = KindDeadlock
+	case 1205, 3572:
+		de.Kind = KindLockTimeout
+	case 1317:
+		de.Kind = KindQueryCanceled
+	case 3024:
+		// 超出max_execution_time。
+		de.Kind = KindQueryTimeout
+	case 1053, 1927:
+		de.Kind = KindConnectionLost
+	}
+	return de
+}
+
+func classifyPostgres(pe *pq.Error) *DbError {
+	de := &DbError{Code: string(pe.Code), Table: pe.Table, Column: pe.Column, Constraint: pe.Constraint, Err: pe}
+	switch pe.Code {
+	case "23505":
+		de.Kind = KindUniqueViolation
+	case "23503":
+		de.Kind = KindForeignKeyViolation
+	case "23502":
+		de.Kind = KindNotNullViolation
+	case "23514":
+		de.Kind = KindCheckViolation
+	case "40P01":
+		de.Kind = KindDeadlock
+	case "55P03":
+		de.Kind = KindLockTimeout
+	case "40001":
+		de.Kind = KindSerializationFailure
+	case "57014":
+		if strings.Contains(pe.Message, "statement timeout") {
+			de.Kind = KindQueryTimeout
+		} else {
+			de.Kind = KindQueryCanceled
+		}
+	case "57P01", "57P02", "57P03":
+		de.Kind = KindConnectionLost
+	default:
+		if pe.Code.Class() == "08" {
+			de.Kind = KindConnectionLost
+		}
+	}
+	return de
+}
+
+// SQLite的错误码，与go-sqlite3中的ErrNo和ErrNoExtended一致。
+const (
+	sqliteBusy                = 5
+	sqliteLocked              = 6
+	sqliteInterrupt           = 9
+	sqliteConstraintCheck     = 275
+	sqliteConstraintForeign   = 787
+	sqliteConstraintNotNull   = 1299
+	sqliteConstraintPrimary   = 1555
+	sqliteConstraintUnique    = 2067
+	sqliteDriverPkgPath       = "github.com/mattn/go-sqlite3"
+	sqliteDriverErrorTypeName = "Error"
+)
+
+// asSqliteError 从错误链中查找go-sqlite3的Error，返回其错误码和扩展错误码。
+// go-sqlite3依赖cgo，为了在CGO_ENABLED=0时也能编译，这里通过反射读取错误码而不引用go-sqlite3的类型。
+func asSqliteError(err error) (error, int, int, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		if v.Kind() == reflect.Pointer && !v.IsNil() {
+			v = v.Elem()
+		}
+		if t := v.Type(); t.Kind() != reflect.Struct || t.PkgPath() != sqliteDriverPkgPath || t.Name() != sqliteDriverErrorTypeName {
+			continue
+		}
+		code, extCode := v.FieldByName("Code"), v.FieldByName("ExtendedCode")
+		if code.Kind() == reflect.Int && extCode.Kind() == reflect.Int {
+			return err, int(code.Int()), int(extCode.Int()), true
+		}
+	}
+	return nil, 0, 0, false
+}
+
+func classifySqlite(se error, code, extCode int) *DbError {
+	de := &DbError{Code: strconv.Itoa(extCode), Err: se}
+	switch extCode {
+	case sqliteConstraintUnique, sqliteConstraintPrimary:
+		de.Kind = KindUniqueViolation
+	case sqliteConstraintForeign:
+		de.Kind = KindForeignKeyViolation
+	case sqliteConstraintNotNull:
+		de.Kind = KindNotNullViolation
+	case sqliteConstraintCheck:
+		de.Kind = KindCheckViolation
+	default:
+		switch code {
+		case sqliteBusy, sqliteLocked:
+			de.Kind = KindLockTimeout
+		case sqliteInterrupt:
+			de.Kind = KindQueryCanceled
+		}
+	}
+
+	// SQLite的错误信息形如"UNIQUE constraint failed: user.user_name"或者"CHECK constraint failed: ck_name"。
+	if de.Kind != KindUnknown && de.Kind != KindForeignKeyViolation {
+		msg := se.Error()
+		if i := strings.Index(msg, "constraint failed: "); i >= 0 {
+			target := msg[i+len("constraint failed: "):]
+			if de.Kind == KindCheckViolation {
+				de.Constraint = target
+			} else if j := strings.IndexByte(target, '.'); j >= 0 {
+				de.Table, de.Column = target[:j], strings.SplitN(target[j+1:], ",", 2)[0]
+			}
+		}
+	}
+	return de
+}
+
+// isForeignKeyViolation 判断错误是否为外键约束违反。
+func isForeignKeyViolation(err error) bool {
+	return KindOf(err) == KindForeignKeyViolation
+}
+
+// isRetryableTxError 判断错误是否为死锁、锁等待超时或者序列化失败，重试整个事务可能成功。
+func isRetryableTxError(err error) bool {
+	switch KindOf(err) {
+	case KindDeadlock, KindLockTimeout, KindSerializationFailure:
+		return true
+	default:
+		return false
+	}
+}
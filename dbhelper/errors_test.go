@@ -0,0 +1,67 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err        error
+		kind       error
+		table      string
+		column     string
+		constraint string
+	}{
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'admin' for key 'user.uk_user_name'"}, ErrUniqueViolation, "user", "", "uk_user_name"},
+		{&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`insight`.`user_role`, CONSTRAINT `fk_user_role_user` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`))"}, ErrForeignKeyViolation, "user_role", "user_id", "fk_user_role_user"},
+		{&mysql.MySQLError{Number: 1048, Message: "Column 'user_name' cannot be null"}, ErrNotNullViolation, "", "user_name", ""},
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, ErrDeadlock, "", "", ""},
+		{&pq.Error{Code: "23505", Table: "user", Constraint: "uk_user_name"}, ErrUniqueViolation, "user", "", "uk_user_name"},
+		{&pq.Error{Code: "23502", Table: "user", Column: "user_name"}, ErrNotNullViolation, "user", "user_name", ""},
+		{&pq.Error{Code: "40001"}, ErrSerializationFailure, "", "", ""},
+		{&pq.Error{Code: "08006"}, ErrConnectionLost, "", "", ""},
+		{context.DeadlineExceeded, ErrQueryCanceled, "", "", ""},
+		{context.DeadlineExceeded, ErrQueryTimeout, "", "", ""},
+		{&pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"}, ErrQueryTimeout, "", "", ""},
+	}
+
+	for _, c := range cases {
+		err := ClassifyError(c.err)
+		var de *DbError
+		if !errors.Is(err, c.kind) || !errors.As(err, &de) {
+			t.Errorf("ClassifyError(%v) => %v, want %v", c.err, err, c.kind)
+		} else if de.Table != c.table || de.Column != c.column || de.Constraint != c.constraint {
+			t.Errorf("ClassifyError(%v) => %q %q %q, want %q %q %q", c.err, de.Table, de.Column, de.Constraint, c.table, c.column, c.constraint)
+		}
+	}
+
+	if err := ClassifyError(errors.New("foo")); KindOf(err) != KindUnknown {
+		t.Errorf("ClassifyError(foo) => %v, want unknown", err)
+	}
+}
+
+func TestSqliteErrors(t *testing.T) {
+	ctx := context.TODO()
+
+	MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "error1")
+	_, err := Exec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "error1")
+	var de *DbError
+	if !errors.Is(err, ErrUniqueViolation) || !errors.As(err, &de) {
+		t.Errorf("Exec(duplicate) => %v, want ErrUniqueViolation", err)
+	} else if de.Table != "user" || de.Column != "user_name" {
+		t.Errorf("Exec(duplicate) => %q %q, want user user_name", de.Table, de.Column)
+	}
+
+	if _, err := Exec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", nil); !errors.Is(err, ErrNotNullViolation) {
+		t.Errorf("Exec(null) => %v, want ErrNotNullViolation", err)
+	}
+
+	if _, err := Exec[int](ReportForeignKeyViolation(ctx, true), "INSERT INTO user_role (user_id, role_name) VALUES (:1, :2)", -1, "admin"); !errors.Is(err, ErrForeignKeyViolation) {
+		t.Errorf("Exec(fk) => %v, want ErrForeignKeyViolation", err)
+	}
+}
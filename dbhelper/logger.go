@@ -0,0 +1,331 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lord-Haart/go-common/utils"
+)
+
+// QueryEvent 表示一次sql执行的信息。
+type QueryEvent struct {
+	DataSource   string        // 数据源名称。
+	Replica      string        // 在从库上执行时为从库的名称，在主库上执行时为空。
+	Query        string        // 改写后实际执行的sql，事务操作对应BEGIN、COMMIT、ROLLBACK、SAVEPOINT等语句。
+	Args         []any         // 实际执行时的参数，未经遮盖。
+	Duration     time.Duration // 执行耗时，包括准备语句和读取结果的时间。
+	RowsAffected int64         // 受影响或者返回的行数，未知时为-1。
+	Err          error         // 执行时发生的错误。
+	InTx         bool          // 是否在事务中执行。
+}
+
+// QueryLogger 用于记录sql执行的日志。实现必须是并发安全的。
+type QueryLogger interface {
+	LogQuery(ctx context.Context, e *QueryEvent)
+}
+
+// StdQueryLogger 使用标准库log记录sql，这是默认的日志记录器。
+// 普通sql使用[DEBUG]级别，慢sql使用[WARN]级别，出错的sql使用[ERROR]级别。
+type StdQueryLogger struct {
+	Logger        *log.Logger   // 为nil时使用log.Default()。
+	SlowThreshold time.Duration // 耗时超过该值的sql视为慢sql，小于等于0时不检查。
+	SlowOnly      bool          // 是否只记录慢sql和出错的sql。
+	RedactColumns []string      // 需要遮盖参数的列名，为nil时使用DefaultRedactColumns。
+}
+
+// SlogQueryLogger 使用log/slog记录sql。
+// 普通sql使用Level级别，慢sql使用slog.LevelWarn级别，出错的sql使用slog.LevelError级别。
+type SlogQueryLogger struct {
+	Logger        *slog.Logger  // 为nil时使用slog.Default()。
+	Level         slog.Level    // 普通sql的日志级别。
+	SlowThreshold time.Duration // 耗时超过该值的sql视为慢sql，小于等于0时不检查。
+	RedactColumns []string      // 需要遮盖参数的列名，为nil时使用DefaultRedactColumns。
+}
+
+// loggerHolder 用于在atomic.Value中保存可能为nil的QueryLogger。
+type loggerHolder struct {
+	l QueryLogger
+}
+
+const redactedArg = "******"
+
+var (
+	// DefaultRedactColumns 默认需要遮盖参数的列名，列名包含其中任意一项（不区分大小写）时参数被遮盖。
+	DefaultRedactColumns = []string{"password", "passwd", "pwd", "secret", "token"}
+
+	queryLogger atomic.Value // loggerHolder
+
+	redactComparePattern = regexp.MustCompile("(?i)([a-z_][a-z0-9_]*)[`\"]?\\s*(?:=|<>|!=|<=|>=|<|>|\\sLIKE|\\sIN\\s*\\()\\s*$")
+	redactInsertPattern  = regexp.MustCompile(`(?is)^\s*INSERT\s+(?:[a-z]+\s+)*INTO\s+\S+\s*\(([^)]*)\)\s*VALUES\s*`)
+)
+
+func init() {
+	queryLogger.Store(loggerHolder{l: &StdQueryLogger{}})
+}
+
+// SetQueryLogger 设置sql日志记录器，nil表示不记录sql。
+func SetQueryLogger(l QueryLogger) {
+	queryLogger.Store(loggerHolder{l: l})
+}
+
+// GetQueryLogger 获取当前的sql日志记录器。
+func GetQueryLogger() QueryLogger {
+	return queryLogger.Load().(loggerHolder).l
+}
+
+// NewSlogQueryLogger 创建使用log/slog的日志记录器，普通sql使用slog.LevelDebug级别，超过1秒的sql视为慢sql。
+func NewSlogQueryLogger(logger *slog.Logger) *SlogQueryLogger {
+	return &SlogQueryLogger{Logger: logger, Level: slog.LevelDebug, SlowThreshold: time.Second}
+}
+
+// reportQuery 将sql的执行信息报告给日志记录器。
+func reportQuery(ctx context.Context, e *QueryEvent) {
+	observeQuery(e)
+	if l := GetQueryLogger(); l != nil {
+		l.LogQuery(ctx, e)
+	}
+}
+
+func (l *StdQueryLogger) LogQuery(ctx context.Context, e *QueryEvent) {
+	level := "DEBUG"
+	if e.Err != nil {
+		level = "ERROR"
+	} else if l.SlowThreshold > 0 && e.Duration > l.SlowThreshold {
+		level = "WARN"
+	} else if l.SlowOnly {
+		return
+	}
+
+	buf := make([]string, 0, len(e.Args)+1)
+	query := e.Query
+	if e.InTx {
+		query = "[TX] " + query
+	}
+	buf = append(buf, query)
+	for i, arg := range RedactArgs(e.Query, e.Args, l.RedactColumns) {
+		buf = append(buf, fmt.Sprintf("  [%d] %s", i+1, formatArg(arg)))
+	}
+
+	summary := fmt.Sprintf("(%s, %s)", e.Duration, e.DataSource)
+	if e.RowsAffected >= 0 {
+		summary = fmt.Sprintf("(%s, %d rows, %s)", e.Duration, e.RowsAffected, e.DataSource)
+	}
+	if e.Err != nil {
+		summary += " " + e.Err.Error()
+	}
+
+	logger := l.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("[%s] Execute sql: %s\n%s\n", level, strings.Join(buf, "\n"), summary)
+}
+
+func (l *SlogQueryLogger) LogQuery(ctx context.Context, e *QueryEvent) {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	level := l.Level
+	msg := "Execute sql"
+	if e.Err != nil {
+		level = slog.LevelError
+		msg = "Execute sql failed"
+	} else if l.SlowThreshold > 0 && e.Duration > l.SlowThreshold {
+		level = slog.LevelWarn
+		msg = "Slow sql"
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	args := RedactArgs(e.Query, e.Args, l.RedactColumns)
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = argValue(arg)
+	}
+
+	attrs := []slog.Attr{
+		slog.String("datasource", e.DataSource),
+		slog.String("sql", e.Query),
+		slog.Any("args", values),
+		slog.Duration("duration", e.Duration),
+		slog.Int64("rows", e.RowsAffected),
+		slog.Bool("tx", e.InTx),
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// RedactArgs 遮盖sql中与敏感列对应的参数，返回新的参数列表。
+// sql必须是改写后使用?或者$n作为占位符的sql，参数与列的对应关系根据"列 = 占位符"形式的比较和INSERT语句的列清单推断。
+// columns为nil时使用DefaultRedactColumns。
+func RedactArgs(query string, args []any, columns []string) []any {
+	if columns == nil {
+		columns = DefaultRedactColumns
+	}
+	if len(args) == 0 || len(columns) == 0 {
+		return args
+	}
+
+	// INSERT语句的列清单，VALUES之后的占位符按照在元组中的位置对应到列。
+	var insertCols []string
+	valuesStart := -1
+	if m := redactInsertPattern.FindStringSubmatchIndex(query); m != nil {
+		for _, col := range strings.Split(query[m[2]:m[3]], ",") {
+			insertCols = append(insertCols, strings.Trim(strings.TrimSpace(col), "`\"[]"))
+		}
+		valuesStart = m[1]
+	}
+
+	result, copied := args, false
+	redact := func(argIdx int, col string) {
+		if argIdx < 0 || argIdx >= len(args) || col == "" {
+			return
+		}
+		lc := strings.ToLower(col)
+		for _, c := range columns {
+			if strings.Contains(lc, strings.ToLower(c)) {
+				if !copied {
+					result, copied = append([]any(nil), args...), true
+				}
+				result[argIdx] = redactedArg
+				return
+			}
+		}
+	}
+
+	seq, depth, tuplePos := 0, 0, 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// 跳过字符串和带引号的标识符。
+			if j := strings.IndexByte(query[i+1:], c); j >= 0 {
+				i += j + 1
+			}
+		case valuesStart >= 0 && i >= valuesStart && c == '(':
+			depth++
+			if depth == 1 {
+				tuplePos = 0
+			}
+		case valuesStart >= 0 && i >= valuesStart && c == ')':
+			depth--
+		case valuesStart >= 0 && i >= valuesStart && c == ',' && depth == 1:
+			tuplePos++
+		case c == '?' || (c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9'):
+			argIdx, pos := seq, i
+			if c == '$' {
+				j := i + 1
+				for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+					j++
+				}
+				n, _ := strconv.Atoi(query[i+1 : j])
+				argIdx = n - 1
+				i = j - 1
+			}
+			seq++
+
+			if valuesStart >= 0 && i >= valuesStart && depth == 1 && tuplePos < len(insertCols) {
+				redact(argIdx, insertCols[tuplePos])
+			} else if m := redactComparePattern.FindStringSubmatch(query[max(0, pos-128):pos]); m != nil {
+				redact(argIdx, m[1])
+			}
+		}
+	}
+	return result
+}
+
+// argValue 将可以为空的参数转换为其实际值，无效的值转换为nil。
+func argValue(arg any) any {
+	switch v := arg.(type) {
+	case sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	case sql.NullInt64:
+		if v.Valid {
+			return v.Int64
+		}
+	case sql.NullInt32:
+		if v.Valid {
+			return v.Int32
+		}
+	case sql.NullInt16:
+		if v.Valid {
+			return v.Int16
+		}
+	case sql.NullByte:
+		if v.Valid {
+			return v.Byte
+		}
+	case sql.NullFloat64:
+		if v.Valid {
+			return v.Float64
+		}
+	case sql.NullBool:
+		if v.Valid {
+			return v.Bool
+		}
+	case sql.NullTime:
+		if v.Valid {
+			return v.Time
+		}
+	case utils.String:
+		if v.Valid {
+			return v.V
+		}
+	case utils.Integer:
+		if v.Valid {
+			return v.V
+		}
+	case utils.Short:
+		if v.Valid {
+			return v.V
+		}
+	case utils.Long:
+		if v.Valid {
+			return v.V
+		}
+	case utils.Boolean:
+		if v.Valid {
+			return v.V
+		}
+	case utils.Timestamp:
+		if v.Valid {
+			return v.V
+		}
+	default:
+		return arg
+	}
+	return nil
+}
+
+// formatArg 格式化参数用于输出日志。
+func formatArg(arg any) string {
+	av := argValue(arg)
+	if t, ok := av.(time.Time); ok {
+		return t.Format("2006-01-02T15:04:05-0700")
+	} else if j, ok := av.(int64); ok {
+		return strconv.FormatInt(j, 10)
+	} else if j, ok := av.(int32); ok {
+		return strconv.FormatInt(int64(j), 10)
+	} else if j, ok := av.(int); ok {
+		return strconv.Itoa(j)
+	} else if j, ok := av.(float64); ok {
+		return fmt.Sprintf("%.2f", j)
+	} else {
+		return fmt.Sprintf("%#v", av)
+	}
+}
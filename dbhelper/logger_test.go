@@ -0,0 +1,84 @@
+package dbhelper
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type captureLogger struct {
+	mu     sync.Mutex
+	events []QueryEvent
+}
+
+func (l *captureLogger) LogQuery(ctx context.Context, e *QueryEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, *e)
+}
+
+func TestRedactArgs(t *testing.T) {
+	cases := []struct {
+		query string
+		args  []any
+		want  []any
+	}{
+		{"SELECT * FROM user WHERE user_name = ? AND password = ?", []any{"admin", "123"}, []any{"admin", "******"}},
+		{"UPDATE user SET `password`=$2 WHERE id = $1", []any{1, "123"}, []any{1, "******"}},
+		{"INSERT INTO user (user_name, password_hash) VALUES (?, ?), (?, ?)", []any{"a", "1", "b", "2"}, []any{"a", "******", "b", "******"}},
+		{"SELECT * FROM user WHERE remark = 'password = ?' AND id = ?", []any{1}, []any{1}},
+	}
+
+	for _, c := range cases {
+		if got := RedactArgs(c.query, c.args, nil); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("RedactArgs(%q) => %v, want %v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestQueryLogger(t *testing.T) {
+	ctx := context.TODO()
+	l := &captureLogger{}
+	SetQueryLogger(l)
+	defer SetQueryLogger(&StdQueryLogger{})
+
+	txCtx := BeginTx(ctx, false)
+	MustExec[int](txCtx, "INSERT INTO user (user_name) VALUES (:1)", "logger1")
+	CommitTx(txCtx)
+	CloseTx(txCtx)
+	MustQuery[string](ctx, "SELECT user_name FROM user WHERE user_name = :1", "logger1")
+
+	var queries []string
+	for _, e := range l.events {
+		queries = append(queries, e.Query)
+	}
+	want := []string{"BEGIN", "INSERT INTO user (user_name) VALUES (?)", "COMMIT", "SELECT user_name FROM user WHERE user_name = ?"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Fatalf("queries => %q, want %q", queries, want)
+	}
+	if e := l.events[1]; !e.InTx || e.RowsAffected != 1 || e.DataSource != DefaultDataSource || e.Duration <= 0 {
+		t.Errorf("insert event => %+v", e)
+	}
+	if e := l.events[3]; e.InTx || e.RowsAffected != 1 || e.Args[0] != "logger1" {
+		t.Errorf("select event => %+v", e)
+	}
+}
+
+func TestSlogQueryLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewSlogQueryLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	l.LogQuery(context.TODO(), &QueryEvent{Query: "SELECT 1", RowsAffected: 1})
+	if buf.Len() != 0 {
+		t.Errorf("LogQuery(debug) => %q, want nothing", buf.String())
+	}
+
+	l.LogQuery(context.TODO(), &QueryEvent{Query: "UPDATE user SET password = ?", Args: []any{"123"}, Duration: 2 * l.SlowThreshold})
+	if s := buf.String(); !strings.Contains(s, "level=WARN") || !strings.Contains(s, "******") || strings.Contains(s, "123") {
+		t.Errorf("LogQuery(slow) => %q", s)
+	}
+}
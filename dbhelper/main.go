@@ -4,16 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
-	"log"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/Lord-Haart/go-common/utils"
+	"github.com/Lord-Haart/go-common/encryption"
 	"github.com/go-sql-driver/mysql"
-	"github.com/lib/pq"
 )
 
 type DbDialect string
@@ -21,317 +19,359 @@ type DbDialect string
 const (
 	DialectMySQL    DbDialect = "mysql"
 	DialectPostgres DbDialect = "postgres"
+	DialectSQLite   DbDialect = "sqlite"
 )
 
 type DbRow interface {
 	Scan(dest ...any) error
 }
 
-// ctxKey 用于在上下文中记录事务对象的key。
-type ctxKey struct{}
+var (
+	// SQL_ARG_PATTERN 匹配位置参数占位符:1到:99。
+	//
+	// Deprecated: 改写sql时使用能够跳过字符串和注释的扫描器，不再使用该正则表达式。
+	SQL_ARG_PATTERN = regexp.MustCompile(`:[1-9][0-9]?`)
+)
 
-type ctxRef struct {
-	tx    *sql.Tx
-	alive bool
+// GetDialect 获取默认数据源的方言。
+func GetDialect() DbDialect {
+	if ds := GetDataSource(DefaultDataSource); ds != nil {
+		return ds.dialect
+	}
+	return DialectMySQL
 }
 
-func (cr *ctxRef) Commit() error {
-	if cr.alive {
-		if err := cr.tx.Commit(); err != nil {
-			return err
-		} else {
-			cr.alive = false
-			log.Printf("[DEBUG] Committed transaction\n")
-			return nil
-		}
+// InitMySqlDb 初始化默认的MySql数据源。
+func InitMySqlDb(addr, username, password, dbname string, opts ...Option) error {
+	return InitMySqlDataSource(DefaultDataSource, addr, username, password, dbname, opts...)
+}
+
+// InitPostgresDb 初始化默认的PostgreSQL数据源。
+func InitPostgresDb(host string, port int, username, password, dbname string, opts ...Option) error {
+	return InitPostgresDataSource(DefaultDataSource, host, port, username, password, dbname, opts...)
+}
+
+// InitSqliteDb 初始化默认的SQLite数据源。
+// path 数据库文件的路径，":memory:"表示内存数据库。
+func InitSqliteDb(path string, opts ...Option) error {
+	return InitSqliteDataSource(DefaultDataSource, path, opts...)
+}
+
+// InitMySqlDataSource 初始化指定名称的MySql数据源。
+// password可以是encryption.TryDecrypt支持的ENC(...)形式的密文。
+func InitMySqlDataSource(name, addr, username, password, dbname string, opts ...Option) error {
+	o := newOptions(opts)
+	if db_, err := openMySqlDb(addr, username, password, dbname, o); err != nil {
+		return err
 	} else {
-		return nil
+		return openDataSource(name, db_, DialectMySQL, o)
 	}
 }
 
-func (cr *ctxRef) Close() error {
-	if cr.alive {
-		if err := cr.tx.Rollback(); err != nil {
-			return err
-		} else {
-			cr.alive = false
-			log.Printf("[DEBUG] Rollback transaction\n")
-			return nil
-		}
+// InitPostgresDataSource 初始化指定名称的PostgreSQL数据源。
+// password可以是encryption.TryDecrypt支持的ENC(...)形式的密文。默认不使用TLS，可以通过WithTLS修改。
+func InitPostgresDataSource(name, host string, port int, username, password, dbname string, opts ...Option) error {
+	o := newOptions(opts)
+	if db_, err := openPostgresDb(host, port, username, password, dbname, o); err != nil {
+		return err
 	} else {
-		return nil
+		return openDataSource(name, db_, DialectPostgres, o)
 	}
 }
 
-var (
-	db              *sql.DB
-	dialect         DbDialect = DialectMySQL
-	SQL_ARG_PATTERN            = regexp.MustCompile(`:[1|2|3|4|5|6|7|8|9](0|1|2|3|4|5|6|7|8|9)?`)
-)
-
-func GetDialect() DbDialect { return dialect }
-
-// InitMySqlDb 初始化MySql数据源。
-func InitMySqlDb(addr, username, password, dbname string) error {
+// openMySqlDb 创建MySql的连接池，不检查连接。
+func openMySqlDb(addr, username, password, dbname string, o *dbOptions) (*sql.DB, error) {
 	cfg := mysql.NewConfig()
 	cfg.Net = "tcp"
 	cfg.Addr = addr
 	cfg.User = username
-	cfg.Passwd = password
 	cfg.DBName = dbname
 	cfg.Loc = time.Local
 	cfg.ParseTime = true
-	if db_, err := sql.Open("mysql", cfg.FormatDSN()); err != nil {
-		return err
+	if p, err := encryption.TryDecrypt(password); err != nil {
+		return nil, err
 	} else {
-		db_.SetConnMaxLifetime(300 * time.Second)
-		db_.SetMaxIdleConns(2)
-		if err := db_.Ping(); err != nil {
-			return err
-		}
+		cfg.Passwd = p
+	}
 
-		db = db_
-		dialect = DialectMySQL
-		return nil
+	cfg, err := o.applyMySql(cfg)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
 	}
+
+	return sql.OpenDB(connector), nil
 }
 
-// InitPostgresDb 初始化PostgreSQL数据源。
-func InitPostgresDb(host string, port int, username, password, dbname string) error {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, username, password, dbname)
-	if db_, err := sql.Open("postgres", dsn); err != nil {
-		return err
-	} else {
-		db_.SetConnMaxLifetime(300 * time.Second)
-		db_.SetMaxIdleConns(2)
-		if err := db_.Ping(); err != nil {
-			return err
+// openPostgresDb 创建PostgreSQL的连接池，不检查连接。
+func openPostgresDb(host string, port int, username, password, dbname string, o *dbOptions) (*sql.DB, error) {
+	p, err := encryption.TryDecrypt(password)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{
+		"host":     host,
+		"port":     strconv.Itoa(port),
+		"user":     username,
+		"password": p,
+		"dbname":   dbname,
+		"sslmode":  "disable",
+	}
+	for k, v := range o.postgresParams() {
+		params[k] = v
+	}
+
+	return sql.Open("postgres", formatPostgresDSN(params))
+}
+
+// InitSqliteDataSource 初始化指定名称的SQLite数据源。
+// 内存数据库只存在于单个连接中，所以此时连接池只保留一个永不过期的连接，忽略连接池的选项，
+// 在事务中执行sql时必须使用事务所在的上下文，否则会因等待连接而阻塞。
+// go-sqlite3依赖cgo，本包不引入该驱动，调用者需要自行导入：import _ "github.com/mattn/go-sqlite3"。
+func InitSqliteDataSource(name, path string, opts ...Option) error {
+	o := newOptions(opts)
+
+	memory := path == ":memory:"
+	dsn := path
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	// go-sqlite3使用参数第一次出现时的值，因此默认值只在path和选项都没有指定时添加。
+	params := map[string]string{"_foreign_keys": "on", "_busy_timeout": "5000", "_loc": "auto"}
+	if i := strings.IndexByte(dsn, '?'); i >= 0 {
+		if q, err := url.ParseQuery(dsn[i+1:]); err == nil {
+			for k := range q {
+				delete(params, k)
+			}
 		}
+	}
+	for k, v := range o.params {
+		params[k] = v
+	}
+	dsn = appendParams(dsn, params)
 
-		db = db_
-		dialect = DialectPostgres
-		return nil
+	if memory {
+		o.maxOpenConns, o.maxIdleConns, o.connMaxLifetime, o.connMaxIdleTime = 1, 1, 0, 0
+	}
+	if db_, err := sql.Open("sqlite3", dsn); err != nil {
+		return err
+	} else {
+		return openDataSource(name, db_, DialectSQLite, o)
 	}
 }
 
-func logSql(query string, args []any) {
-	buf := make([]string, 0, 10)
-
-	buf = append(buf, query)
-	for i, arg := range args {
-		av := any(nil)
+// openDataSource 设置连接池并检查连接，成功后注册数据源。
+func openDataSource(name string, db *sql.DB, dialect DbDialect, o *dbOptions) error {
+	if err := openPool(db, o); err != nil {
+		return err
+	}
 
-		switch v := arg.(type) {
-		case sql.NullString:
-			if v.Valid {
-				av = v.String
-			}
-		case sql.NullInt64:
-			if v.Valid {
-				av = v.Int64
-			}
-		case sql.NullInt32:
-			if v.Valid {
-				av = v.Int32
-			}
-		case sql.NullInt16:
-			if v.Valid {
-				av = v.Int16
-			}
-		case sql.NullByte:
-			if v.Valid {
-				av = v.Byte
-			}
-		case sql.NullFloat64:
-			if v.Valid {
-				av = v.Float64
-			}
-		case sql.NullBool:
-			if v.Valid {
-				av = v.Bool
-			}
-		case sql.NullTime:
-			if v.Valid {
-				av = v.Time
-			}
-		case utils.String:
-			if v.Valid {
-				av = v.V
-			}
-		case utils.Integer:
-			if v.Valid {
-				av = v.V
-			}
-		case utils.Short:
-			if v.Valid {
-				av = v.V
-			}
-		case utils.Long:
-			if v.Valid {
-				av = v.V
-			}
-		case utils.Boolean:
-			if v.Valid {
-				av = v.V
-			}
-		case utils.Timestamp:
-			if v.Valid {
-				av = v.V
-			}
-		default:
-			av = arg
-		}
+	RegisterDataSource(name, db, dialect).SetStatementTimeout(o.stmtTimeout)
+	return nil
+}
 
-		if t, ok := av.(time.Time); ok {
-			buf = append(buf, fmt.Sprintf("  [%d] %s", i+1, t.Format("2006-01-02T15:04:05-0700")))
-		} else if j, ok := av.(int64); ok {
-			buf = append(buf, fmt.Sprintf("  [%d] %d", i+1, j))
-		} else if j, ok := av.(int32); ok {
-			buf = append(buf, fmt.Sprintf("  [%d] %d", i+1, j))
-		} else if j, ok := av.(int); ok {
-			buf = append(buf, fmt.Sprintf("  [%d] %d", i+1, j))
-		} else if j, ok := av.(float64); ok {
-			buf = append(buf, fmt.Sprintf("  [%d] %.2f", i+1, j))
-		} else {
-			buf = append(buf, fmt.Sprintf("  [%d] %#v", i+1, av))
-		}
+// openPool 设置连接池并检查连接，失败时关闭连接池。
+func openPool(db *sql.DB, o *dbOptions) error {
+	o.applyPool(db)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return err
 	}
+	return nil
+}
 
-	log.Printf("[DEBUG] Execute sql: %s\n", strings.Join(buf, "\n"))
+// statement 表示已经准备好的语句，记录了执行信息以便在执行完毕后报告日志。
+type statement struct {
+	*sql.Stmt
+	ds     *DataSource
+	rp     *replica // 在从库上执行时不为nil。
+	inTx   bool
+	query  string
+	args   []any
+	start  time.Time
+	cached *cachedStmt        // 语句来自缓存时不为nil。
+	ctx    context.Context    // 执行语句使用的上下文，包含了超时时间。
+	cancel context.CancelFunc // 关闭语句时调用。
 }
 
-func prepareSql(ctx context.Context, query string, args []any) (*sql.Stmt, []any) {
-	oargs := make([]any, 0, len(args))
-	paramIdx := 0
-	oquery := SQL_ARG_PATTERN.ReplaceAllStringFunc(query, func(s string) string {
-		s0 := s[1:]
-		if v, err := strconv.ParseInt(s0, 10, 64); err != nil {
-			panic(fmt.Errorf("illegal sql arg: %#v", s0))
-		} else {
-			si := int(v)
-			if si <= len(args) {
-				oargs = append(oargs, args[si-1])
-				paramIdx++
-				if dialect == DialectPostgres {
-					return "$" + strconv.Itoa(paramIdx)
-				}
-				return "?"
-			} else {
-				panic(fmt.Errorf("no enough args, wants %d", si))
-			}
-		}
-	})
+// Close 关闭语句，来自缓存的语句被释放回缓存。
+func (st *statement) Close() error {
+	defer st.cancel()
 
-	if cr, ok := ctx.Value(ctxKey{}).(*ctxRef); ok {
-		logSql("[TX] "+oquery, oargs)
-		if !cr.alive {
-			panic(fmt.Errorf("current transaction is not alive"))
-		}
+	if st.cached == nil {
+		return st.Stmt.Close()
+	}
 
-		if stmt, err := cr.tx.Prepare(oquery); err != nil {
-			panic(err)
-		} else {
-			return stmt, oargs
-		}
-	} else {
-		logSql(oquery, oargs)
-		if stmt, err := db.Prepare(oquery); err != nil {
-			panic(err)
-		} else {
-			return stmt, oargs
-		}
+	var err error
+	if st.inTx {
+		// 通过tx.StmtContext得到的语句只属于该事务，关闭它不影响缓存的语句。
+		err = st.Stmt.Close()
+	}
+	if err0 := st.stmtCache().release(st.cached); err == nil {
+		err = err0
 	}
+	return err
 }
 
-func BeginTx(ctx context.Context, serializable bool) context.Context {
-	isolation := sql.LevelDefault
-	if serializable {
-		isolation = sql.LevelSerializable
-	}
+// classify 对执行语句时发生的错误进行分类，因为超时而被取消时返回ErrQueryTimeout。
+func (st *statement) classify(err error) error {
+	return classifyTimeout(st.ctx, err)
+}
 
-	if tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: isolation}); err != nil {
-		panic(err)
-	} else {
-		log.Printf("[DEBUG] Begin transaction\n")
-		return context.WithValue(ctx, ctxKey{}, &ctxRef{tx: tx, alive: true})
+// stmtCache 获取语句所在的预编译语句缓存。
+func (st *statement) stmtCache() *stmtCache {
+	if st.rp != nil {
+		return st.rp.stmts
 	}
+	return st.ds.stmts
 }
 
-func CommitTx(ctx context.Context) {
-	if cr, ok := ctx.Value(ctxKey{}).(*ctxRef); ok {
-		if err := cr.Commit(); err != nil {
-			panic(err)
-		}
+// done 报告语句的执行结果，rows是受影响或者返回的行数，未知时为-1。
+func (st *statement) done(ctx context.Context, rows int64, err error) {
+	var replica string
+	if st.rp != nil {
+		replica = st.rp.name
 	}
+	reportQuery(ctx, &QueryEvent{
+		DataSource:   st.ds.name,
+		Replica:      replica,
+		Query:        st.query,
+		Args:         st.args,
+		Duration:     time.Since(st.start),
+		RowsAffected: rows,
+		Err:          err,
+		InTx:         st.inTx,
+	})
 }
 
-func CloseTx(ctx context.Context) {
-	if cr, ok := ctx.Value(ctxKey{}).(*ctxRef); ok {
-		cr.Close()
+// prepareSql 改写sql中的参数占位符，并在上下文对应的数据源的主库或者事务上准备语句。
+func prepareSql(ctx context.Context, query string, args []any) (*statement, error) {
+	return prepare(ctx, query, args, false)
+}
+
+// prepareQuery 与prepareSql相同，但是在事务之外执行只读sql时优先使用数据源的从库，除非上下文要求使用主库。
+func prepareQuery(ctx context.Context, query string, args []any) (*statement, error) {
+	return prepare(ctx, query, args, true)
+}
+
+func prepare(ctx context.Context, query string, args []any, read bool) (*statement, error) {
+	ds, cr, err := resolveDataSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	oquery, oargs, err := rewriteSql(ds.dialect, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var rp *replica
+	if read && cr == nil && !isPrimaryForced(ctx) && isReadOnlySql(oquery) {
+		// 没有可用的从库时使用主库。
+		rp = ds.pickReplica()
 	}
+	return prepareStmt(ctx, ds, cr, rp, oquery, oargs)
 }
 
-// isForeignKeyViolation 判断错误是否为外键约束违反。
-func isForeignKeyViolation(err error) bool {
-	var me *mysql.MySQLError
-	if errors.As(err, &me) && me.Number == 1452 {
-		return true
+// prepareStmt 在数据源的主库、从库rp或者事务上准备已经改写过的sql，优先使用缓存的预编译语句。
+// 事务中命中缓存时通过tx.StmtContext使用缓存的语句；未命中时直接在事务上准备且不加入缓存，
+// 因为在连接池上准备语句需要另一个连接，连接池耗尽时会导致死锁。
+// 返回的语句的上下文包含了数据源默认的或者WithQueryTimeout指定的超时时间。
+func prepareStmt(ctx context.Context, ds *DataSource, cr *ctxRef, rp *replica, oquery string, oargs []any) (*statement, error) {
+	st := &statement{ds: ds, rp: rp, inTx: cr != nil, query: oquery, args: oargs, start: time.Now()}
+	st.ctx, st.cancel = ds.queryContext(ctx)
+	ctx = st.ctx
+
+	var err error
+	if cr != nil {
+		if !cr.isAlive() {
+			err = ErrTxNotAlive
+		} else if st.cached = ds.stmts.lookup(oquery); st.cached != nil {
+			st.Stmt = cr.tx.StmtContext(ctx, st.cached.stmt)
+		} else {
+			st.Stmt, err = cr.tx.PrepareContext(ctx, oquery)
+		}
+	} else if rp != nil {
+		if st.cached, err = rp.stmts.get(ctx, rp.db, oquery); err == nil {
+			st.Stmt = st.cached.stmt
+		}
+	} else if st.cached, err = ds.stmts.get(ctx, ds.db, oquery); err == nil {
+		st.Stmt = st.cached.stmt
 	}
-	var pe *pq.Error
-	if errors.As(err, &pe) && pe.Code == "23503" {
-		return true
+
+	if err != nil {
+		err = st.classify(err)
+		st.done(ctx, -1, err)
+		st.cancel()
+		return nil, err
 	}
-	return false
+	return st, nil
 }
 
 // Exec 执行指定的sql并返回受影响的行数。
 func Exec[T int | int8 | int16 | int32 | int64](ctx context.Context, query string, args ...any) (T, error) {
-	stmt, args := prepareSql(ctx, query, args)
+	st, err := prepareSql(ctx, query, args)
+	if err != nil {
+		return 0, err
+	}
 
-	defer stmt.Close()
+	defer st.Close()
 
-	if r, err := stmt.ExecContext(ctx, args...); err != nil {
-		if isForeignKeyViolation(err) {
+	if r, err := st.ExecContext(st.ctx, st.args...); err != nil {
+		err = st.classify(err)
+		st.done(ctx, -1, err)
+		if swallowForeignKeyViolation(ctx, err) {
 			return 0, nil
 		} else {
 			return 0, err
 		}
 	} else {
 		result, err := r.RowsAffected()
+		st.done(ctx, result, err)
 		return T(result), err
 	}
 }
 
 // ExecLastInsertId 执行指定的sql并返回插入的ID值。只要sql执行成功，即使未插入任何记录也不会返回错误。
+// 在PostgreSQL和SQLite上，如果sql中没有RETURNING子句，那么自动追加RETURNING id，并使用其返回的值。
+// 需要忽略冲突时应当使用InsertSqlBuilder.OnConflict构造sql。
+// 在PostgreSQL和SQLite上将INSERT IGNORE INTO改写为ON CONFLICT DO NOTHING或者INSERT OR IGNORE INTO的做法已经废弃，
+// 只为兼容旧代码而保留，以后的版本会移除。
 func ExecLastInsertId[T int | int8 | int16 | int32 | int64](ctx context.Context, query string, args ...any) (T, error) {
-	if dialect == DialectPostgres {
+	if d := DialectOf(ctx); d == DialectPostgres || d == DialectSQLite {
+		query = rewriteInsertIgnore(d, query)
 		// PostgreSQL不支持LastInsertId()，需要用RETURNING id。
-		hasIgnore := strings.Contains(strings.ToUpper(query), "INSERT IGNORE")
-		if hasIgnore {
-			query = strings.Replace(query, "INSERT IGNORE INTO", "INSERT INTO", 1)
-		}
 		if !strings.Contains(strings.ToUpper(query), "RETURNING") {
-			suffix := ""
-			if hasIgnore {
-				suffix = " ON CONFLICT DO NOTHING"
-			}
-			query = strings.TrimSpace(query) + suffix + " RETURNING id"
+			query = strings.TrimRight(strings.TrimSpace(query), ";") + " RETURNING id"
+		}
+		if r, err := Query[T](ForcePrimary(ctx), query, args...); err != nil && swallowForeignKeyViolation(ctx, err) {
+			return 0, nil
+		} else {
+			return r, err
 		}
-		return Query[T](ctx, query, args...)
 	}
 
-	stmt, args := prepareSql(ctx, query, args)
+	st, err := prepareSql(ctx, query, args)
+	if err != nil {
+		return 0, err
+	}
 
-	defer stmt.Close()
+	defer st.Close()
 
-	if r, err := stmt.ExecContext(ctx, args...); err != nil {
-		if isForeignKeyViolation(err) {
+	if r, err := st.ExecContext(st.ctx, st.args...); err != nil {
+		err = st.classify(err)
+		st.done(ctx, -1, err)
+		if swallowForeignKeyViolation(ctx, err) {
 			return 0, nil
 		} else {
 			return 0, err
 		}
 	} else {
+		n, _ := r.RowsAffected()
+		st.done(ctx, n, nil)
 		if result, err := r.LastInsertId(); err != nil {
 			return 0, nil
 		} else {
@@ -340,154 +380,197 @@ func ExecLastInsertId[T int | int8 | int16 | int32 | int64](ctx context.Context,
 	}
 }
 
-func Query[T bool | int | int8 | int16 | int32 | int64 | string | time.Time | sql.NullInt64 | sql.NullBool | sql.NullTime](ctx context.Context, query string, args ...any) (T, error) {
-	stmt, args := prepareSql(ctx, query, args)
-
-	defer stmt.Close()
+// rewriteInsertIgnore 将MySQL的INSERT IGNORE INTO改写为PostgreSQL或者SQLite的等价写法，已经废弃，仅用于兼容ExecLastInsertId的旧用法。
+func rewriteInsertIgnore(d DbDialect, query string) string {
+	if !strings.Contains(strings.ToUpper(query), "INSERT IGNORE INTO") {
+		return query
+	}
+	if d == DialectSQLite {
+		return strings.Replace(query, "INSERT IGNORE INTO", "INSERT OR IGNORE INTO", 1)
+	}
+	query = strings.Replace(query, "INSERT IGNORE INTO", "INSERT INTO", 1)
+	if !strings.Contains(strings.ToUpper(query), "RETURNING") {
+		query = strings.TrimRight(strings.TrimSpace(query), ";") + " ON CONFLICT DO NOTHING"
+	}
+	return query
+}
 
-	r := stmt.QueryRowContext(ctx, args...)
+// Query 查询单个值，没有记录时返回T的零值，需要区分没有记录和零值时使用QueryMaybe。
+// T可以是任何database/sql可以扫描的类型，包括float64、[]byte、json.RawMessage、sql.NullString等，
+// 以及实现了sql.Scanner的类型，例如utils.String、utils.Timestamp。
+func Query[T any](ctx context.Context, query string, args ...any) (T, error) {
+	result, _, err := QueryMaybe[T](ctx, query, args...)
+	return result, err
+}
 
+// QueryMaybe 与Query相同，但是通过found返回是否存在记录，没有记录时返回T的零值和false。
+func QueryMaybe[T any](ctx context.Context, query string, args ...any) (T, bool, error) {
 	var result T
-	if err := r.Scan(&result); err != nil {
+	st, err := prepareQuery(ctx, query, args)
+	if err != nil {
+		return result, false, err
+	}
+
+	defer st.Close()
+
+	r := st.QueryRowContext(st.ctx, st.args...)
+
+	if err := r.Scan(scanDest(&result)); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return result, nil
+			st.done(ctx, 0, nil)
+			return result, false, nil
 		} else {
-			return result, err
+			err = st.classify(err)
+			st.done(ctx, -1, err)
+			return result, false, err
 		}
 	} else {
-		return result, err
+		st.done(ctx, 1, nil)
+		return result, true, nil
 	}
 }
 
+// RowHandler 将一条记录映射为对象。不想手写映射代码时可以使用StructMapper。
 type RowHandler[T any] interface {
 	Scan(sc DbRow) (*T, error)
 }
 
 func QueryObj[T any](ctx context.Context, query string, rh RowHandler[T], args ...any) (*T, error) {
-	stmt, args := prepareSql(ctx, query, args)
+	st, err := prepareQuery(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
 
-	defer stmt.Close()
+	defer st.Close()
+
+	// 使用*sql.Rows而不是*sql.Row，以便RowHandler可以获取列名。
+	r, err := st.QueryContext(st.ctx, st.args...)
+	if err != nil {
+		err = st.classify(err)
+		st.done(ctx, -1, err)
+		return nil, err
+	}
 
-	r := stmt.QueryRowContext(ctx, args...)
+	defer r.Close()
 
-	if result, err := rh.Scan(r); err != nil {
+	if !r.Next() {
+		err = st.classify(r.Err())
+		st.done(ctx, 0, err)
+		return nil, err
+	} else if result, err := rh.Scan(r); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			st.done(ctx, 0, nil)
 			return nil, nil
 		} else {
+			err = st.classify(err)
+			st.done(ctx, -1, err)
 			return nil, err
 		}
 	} else {
-		return result, err
+		st.done(ctx, 1, nil)
+		return result, nil
 	}
 }
 
-func QueryList[T bool | int | int8 | int16 | int32 | int64 | string | time.Time | sql.NullInt64 | sql.NullBool | sql.NullTime](ctx context.Context, query string, args ...any) ([]T, error) {
-	stmt, args := prepareSql(ctx, query, args)
+// QueryList 查询单列的值列表，T的要求与Query相同。
+func QueryList[T any](ctx context.Context, query string, args ...any) ([]T, error) {
+	st, err := prepareQuery(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
 
-	defer stmt.Close()
+	defer st.Close()
 
-	if r, err := stmt.QueryContext(ctx, args...); err != nil {
+	if r, err := st.QueryContext(st.ctx, st.args...); err != nil {
+		err = st.classify(err)
+		st.done(ctx, -1, err)
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		} else {
 			return nil, err
 		}
 	} else {
+		defer r.Close()
+
 		result := make([]T, 0, 10)
 		for r.Next() {
 			var item T
-			if err := r.Scan(&item); err != nil {
+			if err := r.Scan(scanDest(&item)); err != nil {
+				err = st.classify(err)
+				st.done(ctx, int64(len(result)), err)
 				return result, err
 			} else {
 				result = append(result, item)
 			}
 		}
 
-		return result, nil
+		err = st.classify(r.Err())
+		st.done(ctx, int64(len(result)), err)
+		return result, err
 	}
 }
 
 // QueryObjList 查询对象列表。
 func QueryObjList[T any](ctx context.Context, query string, rh RowHandler[T], args ...any) ([]*T, error) {
-	stmt, args := prepareSql(ctx, query, args)
+	st, err := prepareQuery(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
 
-	defer stmt.Close()
+	defer st.Close()
 
-	if r, err := stmt.QueryContext(ctx, args...); err != nil {
+	if r, err := st.QueryContext(st.ctx, st.args...); err != nil {
+		err = st.classify(err)
+		st.done(ctx, -1, err)
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		} else {
 			return nil, err
 		}
 	} else {
+		defer r.Close()
+
 		result := make([]*T, 0, 10)
 		for r.Next() {
 			if item, err := rh.Scan(r); err != nil {
+				err = st.classify(err)
+				st.done(ctx, int64(len(result)), err)
 				return result, err
 			} else {
 				result = append(result, item)
 			}
 		}
 
-		return result, nil
-	}
-}
-
-func QueryKeyValueMap[T bool | int | int8 | int16 | int32 | int64 | string](ctx context.Context, query string, args ...any) (map[string]T, error) {
-	if result, err := QueryObjList[KeyValuePairPo[T]](ctx, query, &KeyValuePairMapper[T]{}, args...); err != nil {
-		return nil, err
-	} else {
-		m := make(map[string]T, len(result))
-		for _, kv := range result {
-			m[kv.Key] = kv.Value
-		}
-		return m, nil
+		err = st.classify(r.Err())
+		st.done(ctx, int64(len(result)), err)
+		return result, err
 	}
 }
 
-// insertBatch 批量插入记录。
-// 返回成功插入的记录数。
-func InsertBatch(ctx context.Context, query string, rows ...[]any) (int64, error) {
-	if tx, err := db.BeginTx(ctx, &sql.TxOptions{}); err != nil {
-		return 0, err
-	} else if stmt, err := tx.Prepare(query); err != nil {
-		return 0, err
-	} else {
-		c := int64(0)
-		re := error(nil)
-
-		for _, row := range rows {
-			if row == nil {
-				continue
-			}
-
-			if r, err := stmt.ExecContext(ctx, row...); err != nil {
-				if re != nil {
-					re = err
-				}
-			} else if c_, err := r.RowsAffected(); err != nil {
-				panic(err)
-			} else {
-				c += c_
-			}
-		}
-
-		return c, re
-	}
+// QueryKeyValueMap 查询两列的记录，第一列作为字符串key，第二列作为value。
+// key为其它类型或者需要分组时使用QueryMap或者QueryGroupMap。
+func QueryKeyValueMap[T any](ctx context.Context, query string, args ...any) (map[string]T, error) {
+	return QueryMap[string, T](ctx, query, args...)
 }
 
+// JoinInString 将字符串列表拼接为IN子句的字面值，例如('a','b')，字符串中的引号和反斜杠会被转义。
+//
+// Deprecated: 字面值无法利用预编译语句缓存，应当使用切片参数，例如Query(ctx, "... WHERE name IN (:1)", names)。
 func JoinInString(args []string) string {
 	if len(args) == 0 {
 		return "('')"
 	} else {
 		sa := make([]string, 0, len(args))
 		for _, a := range args {
-			sa = append(sa, "'"+a+"'")
+			sa = append(sa, quoteString(GetDialect(), a))
 		}
 		return "(" + strings.Join(sa, ",") + ")"
 	}
 }
 
+// JoinInInt 将整数列表拼接为IN子句的字面值，例如(1,2)。
+//
+// Deprecated: 字面值无法利用预编译语句缓存，应当使用切片参数，例如Query(ctx, "... WHERE id IN (:1)", ids)。
 func JoinInInt[T int | int8 | int16 | int32 | int64](args []T) string {
 	if len(args) == 0 {
 		return "(0)"
@@ -500,15 +583,23 @@ func JoinInInt[T int | int8 | int16 | int32 | int64](args []T) string {
 	}
 }
 
-type KeyValuePairPo[T bool | int | int8 | int16 | int32 | int64 | string] struct {
+// quoteString 将字符串转义为sql字面值，MySQL还需要转义反斜杠。
+func quoteString(d DbDialect, s string) string {
+	if d == DialectMySQL {
+		s = strings.ReplaceAll(s, "\\", "\\\\")
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+type KeyValuePairPo[T any] struct {
 	Key   string
 	Value T
 }
 
-type KeyValuePairMapper[T bool | int | int8 | int16 | int32 | int64 | string] struct{}
+type KeyValuePairMapper[T any] struct{}
 
 func (m *KeyValuePairMapper[T]) Scan(r DbRow) (*KeyValuePairPo[T], error) {
 	result := &KeyValuePairPo[T]{}
-	err := r.Scan(&result.Key, &result.Value)
+	err := r.Scan(&result.Key, scanDest(&result.Value))
 	return result, err
 }
@@ -2,15 +2,33 @@ package dbhelper
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/Lord-Haart/go-common/utils"
 	mysql "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func init() {
-	// db.InitDb("root:123456@(localhost:3306)/insight")
-	if err := InitMySqlDb("localhost", "root", "123456", "insight"); err != nil {
+	if err := InitSqliteDb(":memory:"); err != nil {
+		panic(err)
+	}
+	if _, err := Exec[int](context.TODO(), `CREATE TABLE user (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_name VARCHAR(50) NOT NULL UNIQUE,
+		nick_name VARCHAR(50),
+		password VARCHAR(100),
+		create_time DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		panic(err)
+	}
+	if _, err := Exec[int](context.TODO(), `CREATE TABLE user_role (
+		user_id INTEGER NOT NULL REFERENCES user (id),
+		role_name VARCHAR(50) NOT NULL
+	)`); err != nil {
 		panic(err)
 	}
 }
@@ -28,7 +46,7 @@ func TestFormatDSN(t *testing.T) {
 }
 
 func TestRowsAffected(t *testing.T) {
-	if r0, err := Exec[int](context.TODO(), "INSERT INTO user (user_name, nick_name, password) VALUE (:1, :2, :3)", "admin2", "管理员", utils.Sha256Salt("123456")); err != nil {
+	if r0, err := Exec[int](context.TODO(), "INSERT INTO user (user_name, nick_name, password) VALUES (:1, :2, :3)", "admin2", "管理员", utils.Sha256Salt("123456")); err != nil {
 		t.Fatal(err)
 	} else if r0 != 1 {
 		t.Errorf("InsertOrUpdateUser => %v, want 1", r0)
@@ -43,7 +61,7 @@ func TestUpdateWithTx(t *testing.T) {
 
 	defer CloseTx(ctx)
 
-	if r0, err := Exec[int](ctx, "INSERT INTO user (user_name, nick_name, password) VALUE (:1, :2, :3)", "admin99", "管理员", utils.Sha256Salt("123456")); err != nil {
+	if r0, err := Exec[int](ctx, "INSERT INTO user (user_name, nick_name, password) VALUES (:1, :2, :3)", "admin99", "管理员", utils.Sha256Salt("123456")); err != nil {
 		t.Fatal(err)
 	} else if r0 != 1 {
 		t.Errorf("InsertOrUpdateUser => %v, want 1", r0)
@@ -52,9 +70,100 @@ func TestUpdateWithTx(t *testing.T) {
 	CloseTx(ctx)
 }
 
+func TestExecLastInsertId(t *testing.T) {
+	ctx := context.TODO()
+
+	id, err := ExecLastInsertId[int64](ctx, "INSERT INTO user (user_name, nick_name) VALUES (:1, :2)", "admin3", "管理员")
+	if err != nil {
+		t.Fatal(err)
+	} else if id <= 0 {
+		t.Errorf("ExecLastInsertId => %v, want > 0", id)
+	}
+
+	ignore := NewSqlBuilder("INSERT INTO user").Inserter("").Append("user_name").Append("nick_name").OnConflict().DoNothing().End().String()
+	if id, err := ExecLastInsertId[int64](ctx, ignore, "admin3", "管理员"); err != nil || id != 0 {
+		t.Errorf("ExecLastInsertId(DoNothing) => %v, %v, want 0, nil", id, err)
+	}
+	if id, err := ExecLastInsertId[int64](ctx, "INSERT IGNORE INTO user (user_name, nick_name) VALUES (:1, :2)", "admin3", "管理员"); err != nil || id != 0 {
+		t.Errorf("ExecLastInsertId(INSERT IGNORE) => %v, %v, want 0, nil", id, err)
+	}
+	if q := rewriteInsertIgnore(DialectPostgres, "INSERT IGNORE INTO foo (name) VALUES ($1);"); q != "INSERT INTO foo (name) VALUES ($1) ON CONFLICT DO NOTHING" {
+		t.Errorf("rewriteInsertIgnore(postgres) => %q", q)
+	}
+
+	// 违反外键约束时不返回错误。
+	if r0, err := Exec[int](ctx, "INSERT INTO user_role (user_id, role_name) VALUES (:1, :2)", id+1000, "admin"); err != nil {
+		t.Errorf("Exec(fk) => %v, want nil", err)
+	} else if r0 != 0 {
+		t.Errorf("Exec(fk) => %v, want 0", r0)
+	}
+}
+
+func TestQueryTypes(t *testing.T) {
+	ctx := context.TODO()
+
+	if v := MustQuery[float64](ctx, "SELECT 1.5"); v != 1.5 {
+		t.Errorf("Query[float64] => %v, want 1.5", v)
+	}
+	if v := MustQuery[uint64](ctx, "SELECT 42"); v != 42 {
+		t.Errorf("Query[uint64] => %v, want 42", v)
+	}
+	if v := MustQuery[[]byte](ctx, "SELECT 'abc'"); string(v) != "abc" {
+		t.Errorf("Query[[]byte] => %s, want abc", v)
+	}
+	if v := MustQuery[json.RawMessage](ctx, `SELECT '{"a":1}'`); string(v) != `{"a":1}` {
+		t.Errorf("Query[json.RawMessage] => %s", v)
+	}
+	if v := MustQuery[sql.NullString](ctx, "SELECT NULL"); v.Valid {
+		t.Errorf("Query[sql.NullString] => %v, want null", v)
+	}
+	if v := MustQuery[utils.String](ctx, "SELECT 'abc'"); !v.Valid || v.V != "abc" {
+		t.Errorf("Query[utils.String] => %v, want abc", v)
+	}
+	if v := MustQueryList[utils.Long](ctx, "SELECT 1 UNION ALL SELECT NULL"); len(v) != 2 || v[0].V != 1 || v[1].Valid {
+		t.Errorf("QueryList[utils.Long] => %v", v)
+	}
+
+	if v, found, err := QueryMaybe[int](ctx, "SELECT 0"); err != nil || !found || v != 0 {
+		t.Errorf("QueryMaybe(0) => %v, %v, %v, want 0, true", v, found, err)
+	}
+	if v, found, err := QueryMaybe[int](ctx, "SELECT 1 WHERE 1 = 0"); err != nil || found || v != 0 {
+		t.Errorf("QueryMaybe(no rows) => %v, %v, %v, want 0, false", v, found, err)
+	}
+}
+
 // func TestRowsAffected2(t *testing.T) {
 // 	if r0 := db.InsertOrUpdateWorkTime(context.TODO(), "xxxxxx001", "708513b8257fd6f547c7598b4c", "1080875157529746",
 // 		2, "问题修改2", time.Date(2023, time.February, 7, 0, 0, 0, 0, time.Local), time.Date(2023, time.February, 7, 0, 0, 0, 0, time.Local)); r0 != 1 {
 // 		t.Errorf("InsertOrUpdateWorkTime => %v, want 1", r0)
 // 	}
 // }
+
+func TestPrepareSqlErrors(t *testing.T) {
+	ctx := context.TODO()
+
+	var me ErrMissingArg
+	if _, err := Exec[int](ctx, "UPDATE user SET nick_name = :2 WHERE user_name = :1", "admin"); !errors.As(err, &me) || me.Index != 2 {
+		t.Errorf("Exec(missing arg) => %v, want ErrMissingArg{2}", err)
+	}
+
+	txCtx, err := TryBeginTx(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CloseTx(txCtx)
+	if _, err := Query[int](txCtx, "SELECT COUNT(*) FROM user"); !errors.Is(err, ErrTxNotAlive) {
+		t.Errorf("Query(closed tx) => %v, want ErrTxNotAlive", err)
+	}
+
+	if _, err := TryBeginTx(WithDataSource(ctx, "missing"), false); !errors.Is(err, ErrDataSourceNotFound) {
+		t.Errorf("TryBeginTx(missing) => %v, want ErrDataSourceNotFound", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("MustQuery(bad sql) => no panic")
+		}
+	}()
+	MustQuery[int](ctx, "SELECT COUNT(*) FROM no_such_table")
+}
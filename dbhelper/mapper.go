@@ -0,0 +1,195 @@
+package dbhelper
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructMapper 根据结果集的列名自动将记录映射为结构体T，零值即可使用。
+// 列名和字段的匹配规则：
+//  1. 字段的`db`标签与列名相同（不区分大小写），`db:"-"`表示忽略该字段；
+//  2. 没有`db`标签的字段，忽略大小写和下划线后字段名与列名相同，例如UserName可以匹配user_name和userName；
+//  3. 匿名嵌入的结构体的字段视为外层结构体的字段，外层字段优先。
+//
+// 无法匹配的列会被丢弃。字段的类型可以是任何database/sql可以扫描的类型，包括实现了sql.Scanner的utils.String等类型。
+type StructMapper[T any] struct{}
+
+// columnsRow 表示可以获取列名的记录，*sql.Rows实现了该接口。
+type columnsRow interface {
+	Columns() ([]string, error)
+}
+
+// mappingKey 表示映射计划的缓存key。
+type mappingKey struct {
+	typ  reflect.Type
+	cols string
+}
+
+var (
+	// ErrNoColumns 表示记录无法提供列名，StructMapper只能用于*sql.Rows。
+	ErrNoColumns = errors.New("row does not provide column names")
+
+	scannerType  = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+	fieldIndexes sync.Map // reflect.Type => map[string][]int
+	mappingPlans sync.Map // mappingKey => [][]int
+)
+
+func (m StructMapper[T]) Scan(r DbRow) (*T, error) {
+	cr, ok := r.(columnsRow)
+	if !ok {
+		return nil, ErrNoColumns
+	}
+	cols, err := cr.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(T)
+	rv := reflect.ValueOf(result).Elem()
+	plan := mappingPlanOf(rv.Type(), cols)
+	dest := make([]any, len(plan))
+	for i, index := range plan {
+		if index == nil {
+			dest[i] = new(any)
+		} else {
+			dest[i] = scanDest(fieldByIndex(rv, index).Addr().Interface())
+		}
+	}
+
+	if err := r.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// mappingPlanOf 获取结构体的映射计划，即每个列对应的字段索引，无法匹配的列对应nil。
+func mappingPlanOf(typ reflect.Type, cols []string) [][]int {
+	key := mappingKey{typ: typ, cols: strings.Join(cols, ",")}
+	if plan, ok := mappingPlans.Load(key); ok {
+		return plan.([][]int)
+	}
+
+	indexes := fieldIndexesOf(typ)
+	plan := make([][]int, len(cols))
+	for i, col := range cols {
+		if index, ok := indexes[strings.ToLower(col)]; ok {
+			plan[i] = index
+		} else {
+			plan[i] = indexes[normalizeName(col)]
+		}
+	}
+
+	mappingPlans.Store(key, plan)
+	return plan
+}
+
+// fieldIndexesOf 获取结构体所有可映射字段的索引，key为小写的`db`标签或者规范化的字段名。
+func fieldIndexesOf(typ reflect.Type) map[string][]int {
+	if indexes, ok := fieldIndexes.Load(typ); ok {
+		return indexes.(map[string][]int)
+	}
+
+	indexes := make(map[string][]int)
+	collectFields(typ, nil, indexes, make(map[string]int), 0)
+
+	fieldIndexes.Store(typ, indexes)
+	return indexes
+}
+
+// collectFields 收集结构体的字段，depths记录已收集字段的嵌入深度，较浅的字段优先。
+func collectFields(typ reflect.Type, prefix []int, indexes map[string][]int, depths map[string]int, depth int) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		index := append(append(make([]int, 0, len(prefix)+1), prefix...), i)
+
+		tag := f.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			if f.Anonymous && !f.IsExported() {
+				// 未导出的嵌入指针无法通过反射初始化，与encoding/json相同，忽略其字段。
+				continue
+			}
+			ft = ft.Elem()
+		}
+		if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct && !isLeafType(ft) {
+			collectFields(ft, index, indexes, depths, depth+1)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		var name string
+		if tag != "" {
+			name = strings.ToLower(strings.SplitN(tag, ",", 2)[0])
+		} else {
+			name = normalizeName(f.Name)
+		}
+		if d, ok := depths[name]; !ok || depth < d {
+			indexes[name] = index
+			depths[name] = depth
+		}
+	}
+}
+
+// isLeafType 判断结构体类型是否应当作为一个整体扫描，而不是展开其字段。
+func isLeafType(typ reflect.Type) bool {
+	return typ == timeType || reflect.PointerTo(typ).Implements(scannerType)
+}
+
+// fieldByIndex 获取嵌套的字段，途经的空指针会被初始化。
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// normalizeName 规范化名称，忽略大小写和下划线，使得驼峰命名和蛇形命名可以互相匹配。
+func normalizeName(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+}
+
+// rawMessageDest 将字符串或者字节数组扫描为json.RawMessage。
+// database/sql只能将[]byte赋值给json.RawMessage，而SQLite的TEXT列返回的是字符串。
+type rawMessageDest struct {
+	p *json.RawMessage
+}
+
+func (d rawMessageDest) Scan(src any) error {
+	if src == nil {
+		*d.p = nil
+	} else if s, ok := src.(string); ok {
+		*d.p = json.RawMessage(s)
+	} else if b, ok := src.([]byte); ok {
+		*d.p = append(json.RawMessage(nil), b...)
+	} else {
+		return fmt.Errorf("unsupported Scan, storing driver.Value type %T into type *json.RawMessage", src)
+	}
+	return nil
+}
+
+// scanDest 返回扫描到p时使用的目标，database/sql不能直接扫描的类型被包装为sql.Scanner。
+func scanDest(p any) any {
+	if rm, ok := p.(*json.RawMessage); ok {
+		return rawMessageDest{rm}
+	}
+	return p
+}
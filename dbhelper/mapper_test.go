@@ -0,0 +1,59 @@
+package dbhelper
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Lord-Haart/go-common/utils"
+)
+
+type auditPo struct {
+	CreateTime utils.Timestamp
+}
+
+type userPo struct {
+	auditPo
+	Id       int64
+	UserName string
+	Nick     utils.String `db:"nick_name"`
+	Password *string
+	Ignored  string `db:"-"`
+}
+
+type outerPo struct {
+	*auditPo
+	Id       int64
+	UserName string
+}
+
+func TestStructMapper(t *testing.T) {
+	ctx := context.TODO()
+
+	MustExec[int](ctx, "INSERT INTO user (user_name, nick_name) VALUES (:1, :2)", "mapper1", "映射")
+	MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "mapper2")
+
+	if u, err := QueryObj[userPo](ctx, "SELECT *, 'x' AS ignored, 1 AS extra FROM user WHERE user_name = :1", StructMapper[userPo]{}, "mapper1"); err != nil {
+		t.Fatal(err)
+	} else if u == nil || u.Id <= 0 || u.UserName != "mapper1" || !u.Nick.Eq("映射") || u.Password != nil || u.Ignored != "" {
+		t.Errorf("QueryObj => %#v", u)
+	} else if !u.CreateTime.Valid {
+		t.Errorf("QueryObj.CreateTime => %v, want valid", u.CreateTime)
+	}
+
+	if ul, err := QueryObjList[userPo](ctx, "SELECT id, user_name AS userName, nick_name FROM user WHERE user_name LIKE :1 ORDER BY id", StructMapper[userPo]{}, "mapper%"); err != nil {
+		t.Fatal(err)
+	} else if len(ul) != 2 || ul[0].UserName != "mapper1" || ul[1].Nick.Valid {
+		t.Errorf("QueryObjList => %#v", ul)
+	}
+
+	if u, err := QueryObj[userPo](ctx, "SELECT * FROM user WHERE user_name = :1", StructMapper[userPo]{}, "missing"); err != nil || u != nil {
+		t.Errorf("QueryObj(missing) => %v, %v, want nil, nil", u, err)
+	}
+
+	// 未导出的嵌入指针被忽略，而不是panic。
+	if u, err := QueryObj[outerPo](ctx, "SELECT * FROM user WHERE user_name = :1", StructMapper[outerPo]{}, "mapper1"); err != nil {
+		t.Fatal(err)
+	} else if u == nil || u.UserName != "mapper1" || u.auditPo != nil {
+		t.Errorf("QueryObj(outerPo) => %#v", u)
+	}
+}
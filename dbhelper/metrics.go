@@ -0,0 +1,157 @@
+package dbhelper
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// QueryLatencyBuckets sql执行耗时直方图的桶的上界，单位是秒，必须递增。
+// 修改只对之后第一次执行sql的数据源生效，应当在初始化数据源之前设置。
+var QueryLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyHistogram 按照数据源统计sql的执行耗时和错误次数。
+type latencyHistogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // 与buckets一一对应，不是累计值。
+	count   uint64
+	sum     float64
+	errors  uint64
+}
+
+// queryMetrics 数据源名称到*latencyHistogram的映射。
+var queryMetrics sync.Map
+
+// observeQuery 将sql的执行耗时计入数据源的直方图。
+func observeQuery(e *QueryEvent) {
+	v, ok := queryMetrics.Load(e.DataSource)
+	if !ok {
+		buckets := append([]float64(nil), QueryLatencyBuckets...)
+		v, _ = queryMetrics.LoadOrStore(e.DataSource, &latencyHistogram{buckets: buckets, counts: make([]uint64, len(buckets))})
+	}
+	h := v.(*latencyHistogram)
+
+	seconds := e.Duration.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i := sort.SearchFloat64s(h.buckets, seconds); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += seconds
+	if e.Err != nil {
+		h.errors++
+	}
+}
+
+// WriteMetrics 以Prometheus文本格式输出所有数据源的连接池指标和sql执行耗时的直方图。
+func WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	list := allDataSources()
+
+	type sample struct {
+		labels string
+		value  float64
+	}
+	poolMetric := func(name, typ, help string, value func(sql.DBStats) float64) {
+		var samples []sample
+		for _, ds := range list {
+			samples = append(samples, sample{poolLabels(ds.name, "primary"), value(ds.db.Stats())})
+			for _, r := range ds.replicaList() {
+				samples = append(samples, sample{poolLabels(ds.name, r.name), value(r.db.Stats())})
+			}
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, s := range samples {
+			fmt.Fprintf(bw, "%s{%s} %s\n", name, s.labels, formatFloat(s.value))
+		}
+	}
+
+	poolMetric("dbhelper_pool_max_open_connections", "gauge", "Maximum number of open connections to the database.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	poolMetric("dbhelper_pool_open_connections", "gauge", "Number of established connections both in use and idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	poolMetric("dbhelper_pool_in_use_connections", "gauge", "Number of connections currently in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	poolMetric("dbhelper_pool_idle_connections", "gauge", "Number of idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	poolMetric("dbhelper_pool_wait_count_total", "counter", "Total number of connections waited for.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	poolMetric("dbhelper_pool_wait_duration_seconds_total", "counter", "Total time blocked waiting for a new connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+
+	bw.WriteString("# HELP dbhelper_replica_up Whether the replica is in rotation.\n# TYPE dbhelper_replica_up gauge\n")
+	for _, ds := range list {
+		for _, r := range ds.replicaList() {
+			up := 0
+			if r.healthy.Load() {
+				up = 1
+			}
+			fmt.Fprintf(bw, "dbhelper_replica_up{%s} %d\n", poolLabels(ds.name, r.name), up)
+		}
+	}
+
+	var names []string
+	queryMetrics.Range(func(k, _ any) bool {
+		names = append(names, k.(string))
+		return true
+	})
+	sort.Strings(names)
+
+	bw.WriteString("# HELP dbhelper_query_duration_seconds Time spent executing sql statements.\n# TYPE dbhelper_query_duration_seconds histogram\n")
+	errCounts := make([]uint64, len(names))
+	for i, name := range names {
+		v, _ := queryMetrics.Load(name)
+		h := v.(*latencyHistogram)
+
+		h.mu.Lock()
+		label := "datasource=" + quoteLabel(name)
+		var cumulative uint64
+		for j, le := range h.buckets {
+			cumulative += h.counts[j]
+			fmt.Fprintf(bw, "dbhelper_query_duration_seconds_bucket{%s,le=%q} %d\n", label, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(bw, "dbhelper_query_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(bw, "dbhelper_query_duration_seconds_sum{%s} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(bw, "dbhelper_query_duration_seconds_count{%s} %d\n", label, h.count)
+		errCounts[i] = h.errors
+		h.mu.Unlock()
+	}
+
+	bw.WriteString("# HELP dbhelper_query_errors_total Total number of failed sql statements.\n# TYPE dbhelper_query_errors_total counter\n")
+	for i, name := range names {
+		fmt.Fprintf(bw, "dbhelper_query_errors_total{datasource=%s} %d\n", quoteLabel(name), errCounts[i])
+	}
+
+	return bw.Flush()
+}
+
+// MetricsHandler 返回以Prometheus文本格式输出指标的http.Handler，参见WriteMetrics。
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	})
+}
+
+// poolLabels 构造连接池指标的标签，pool为primary或者从库的名称。
+func poolLabels(ds, pool string) string {
+	return "datasource=" + quoteLabel(ds) + ",pool=" + quoteLabel(pool)
+}
+
+// quoteLabel 按照Prometheus文本格式转义标签的值并加上引号。
+func quoteLabel(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
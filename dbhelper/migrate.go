@@ -0,0 +1,399 @@
+package dbhelper
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration 表示一个版本的数据库迁移。
+type Migration struct {
+	Version  int64  // 版本号，来自文件名的数字前缀。
+	Name     string // 名称，来自文件名中版本号之后的部分。
+	Up       string // 升级的sql。
+	Down     string // 回滚的sql，可以为空，此时该版本无法回滚。
+	Checksum string // 升级sql的SHA-256校验和。
+}
+
+// MigrationStatus 表示一个版本的迁移状态。
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool      // 是否已经执行。
+	AppliedAt time.Time // 执行的时间，未执行时为零值。
+	Modified  bool      // 已执行的迁移文件是否在执行后被修改过。
+	Missing   bool      // 已执行的迁移是否已经没有对应的文件。
+}
+
+// appliedMigration 表示schema_migrations表中的一条记录。
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+var (
+	// MigrationTable 记录已经执行的迁移的表名。
+	MigrationTable = "schema_migrations"
+	// MigrationLockTimeout 等待其它实例释放迁移锁的最长时间，仅用于MySQL，PostgreSQL等待到上下文取消为止。
+	MigrationLockTimeout = 10 * time.Minute
+
+	// ErrMigrationChecksum 表示已经执行的迁移文件被修改过。
+	ErrMigrationChecksum = errors.New("migration checksum mismatch")
+	// ErrMigrationNoDown 表示需要回滚的迁移没有回滚的sql。
+	ErrMigrationNoDown = errors.New("migration has no down sql")
+	// ErrMigrationLock 表示无法获取迁移锁。
+	ErrMigrationLock = errors.New("cannot acquire migration lock")
+
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+// LoadMigrations 从fsys的根目录中加载迁移文件，文件名的格式为NNN_name.up.sql和NNN_name.down.sql，返回按照版本号排序的迁移。
+// 可以使用embed.FS嵌入迁移文件，迁移文件在子目录中时使用fs.Sub。
+func LoadMigrations(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		m := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("illegal migration file %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mg
+		} else if mg.Name != m[2] {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.Up = string(content)
+			sum := sha256.Sum256(content)
+			mg.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mg.Down = string(content)
+		}
+	}
+
+	result := make([]*Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Checksum == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up sql", mg.Version, mg.Name)
+		}
+		result = append(result, mg)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// Migrate 在上下文对应的数据源上按照版本号执行所有尚未执行的迁移，返回执行的迁移个数。
+// 每个迁移在独立的事务中执行，失败时回滚该迁移并停止，之前的迁移保持已执行。
+// MySQL的DDL语句会隐式提交事务，因此包含多条DDL的迁移失败时可能只执行了一部分，需要手工修复。
+// 执行前会校验已执行的迁移文件的校验和，不一致时返回ErrMigrationChecksum。
+// 迁移期间持有数据库的咨询锁，多个实例同时迁移时只有一个会执行，其它的等待之后发现没有需要执行的迁移。
+func Migrate(ctx context.Context, fsys fs.FS) (int, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return 0, err
+	}
+
+	c := 0
+	err = withMigrationLock(ctx, func(ctx context.Context, applied map[int64]*appliedMigration) error {
+		for _, mg := range migrations {
+			if a, ok := applied[mg.Version]; ok && a.Checksum != mg.Checksum {
+				return fmt.Errorf("%w: %d_%s", ErrMigrationChecksum, mg.Version, mg.Name)
+			}
+		}
+
+		for _, mg := range migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, mg, mg.Up, true); err != nil {
+				return err
+			}
+			c++
+		}
+		return nil
+	})
+	return c, err
+}
+
+// Rollback 按照版本号从大到小回滚最近执行的n个迁移，返回回滚的迁移个数。
+// 需要回滚的迁移没有对应的文件或者没有回滚的sql时返回ErrMigrationNoDown，此前的迁移保持已回滚。
+// n小于等于0时不回滚任何迁移。
+func Rollback(ctx context.Context, fsys fs.FS, n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return 0, err
+	}
+	byVersion := make(map[int64]*Migration, len(migrations))
+	for _, mg := range migrations {
+		byVersion[mg.Version] = mg
+	}
+
+	c := 0
+	err = withMigrationLock(ctx, func(ctx context.Context, applied map[int64]*appliedMigration) error {
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions[:min(n, len(versions))] {
+			mg, ok := byVersion[v]
+			if !ok || strings.TrimSpace(mg.Down) == "" {
+				return fmt.Errorf("%w: %d_%s", ErrMigrationNoDown, v, applied[v].Name)
+			}
+			if err := runMigration(ctx, mg, mg.Down, false); err != nil {
+				return err
+			}
+			c++
+		}
+		return nil
+	})
+	return c, err
+}
+
+// Status 获取所有迁移的状态，包括已执行但是已经没有对应文件的迁移，按照版本号排序。
+func Status(ctx context.Context, fsys fs.FS) ([]*MigrationStatus, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if err := createMigrationTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := loadAppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*MigrationStatus, 0, len(migrations))
+	for _, mg := range migrations {
+		s := &MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if a, ok := applied[mg.Version]; ok {
+			s.Applied, s.AppliedAt, s.Modified = true, a.AppliedAt, a.Checksum != mg.Checksum
+			delete(applied, mg.Version)
+		}
+		result = append(result, s)
+	}
+	for _, a := range applied {
+		result = append(result, &MigrationStatus{Version: a.Version, Name: a.Name, Applied: true, AppliedAt: a.AppliedAt, Missing: true})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// runMigration 在独立的事务中执行迁移的sql并更新迁移记录，up为false时表示回滚。
+func runMigration(ctx context.Context, mg *Migration, script string, up bool) error {
+	return WithTx(ctx, &TxOptions{Propagation: PropagationRequiresNew, Retry: &RetryPolicy{MaxAttempts: 1}}, func(ctx context.Context) error {
+		_, cr, err := resolveDataSource(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, stmt := range splitStatements(DialectOf(ctx), script) {
+			start := time.Now()
+			_, err := cr.tx.ExecContext(ctx, stmt)
+			err = ClassifyError(err)
+			cr.report(ctx, stmt, start, err)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+		}
+
+		table := DialectOf(ctx).QuoteIdent(MigrationTable)
+		if up {
+			_, err = Exec[int](ctx, "INSERT INTO "+table+" (version, name, checksum, applied_at) VALUES (:1, :2, :3, :4)",
+				mg.Version, mg.Name, mg.Checksum, time.Now().UTC())
+		} else {
+			_, err = Exec[int](ctx, "DELETE FROM "+table+" WHERE version = :1", mg.Version)
+		}
+		return err
+	})
+}
+
+// createMigrationTable 创建记录已执行迁移的表。
+func createMigrationTable(ctx context.Context) error {
+	_, err := Exec[int](ctx, "CREATE TABLE IF NOT EXISTS "+DialectOf(ctx).QuoteIdent(MigrationTable)+` (
+  version BIGINT NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  checksum VARCHAR(64) NOT NULL,
+  applied_at TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+// loadAppliedMigrations 加载已经执行的迁移。
+func loadAppliedMigrations(ctx context.Context) (map[int64]*appliedMigration, error) {
+	// 从库可能还没有同步最新的迁移记录。
+	list, err := QueryObjList(ForcePrimary(ctx), "SELECT version, name, checksum, applied_at FROM "+DialectOf(ctx).QuoteIdent(MigrationTable), StructMapper[appliedMigration]{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int64]*appliedMigration, len(list))
+	for _, a := range list {
+		result[a.Version] = a
+	}
+	return result, nil
+}
+
+// withMigrationLock 在持有迁移锁期间执行fn，applied是已经执行的迁移。
+// MySQL使用GET_LOCK，PostgreSQL使用pg_advisory_lock，两者都是会话级别的锁，因此需要独占一个连接直到释放锁，
+// 传给fn的上下文中开始的事务都在持有锁的连接上执行，所以连接池的最大连接数为1时也不会死锁。
+// SQLite是嵌入式数据库，不使用咨询锁。
+func withMigrationLock(ctx context.Context, fn func(ctx context.Context, applied map[int64]*appliedMigration) error) error {
+	ds, _, err := resolveDataSource(ctx)
+	if err != nil {
+		return err
+	}
+	if ds.dialect != DialectMySQL && ds.dialect != DialectPostgres {
+		return runLocked(ctx, fn)
+	}
+
+	conn, err := ds.db.Conn(ctx)
+	if err != nil {
+		return ClassifyError(err)
+	}
+
+	defer conn.Close()
+
+	if ds.dialect == DialectMySQL {
+		var ok sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", MigrationTable, int(MigrationLockTimeout.Seconds())).Scan(&ok); err != nil {
+			return ClassifyError(err)
+		} else if ok.Int64 != 1 {
+			return ErrMigrationLock
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", MigrationTable)
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(MigrationTable))
+		key := int64(h.Sum64())
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			return fmt.Errorf("%w: %w", ErrMigrationLock, ClassifyError(err))
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key)
+	}
+
+	return runLocked(withConn(ctx, ds, conn), fn)
+}
+
+// runLocked 在事务中创建迁移表并加载已经执行的迁移，然后执行fn。
+func runLocked(ctx context.Context, fn func(ctx context.Context, applied map[int64]*appliedMigration) error) error {
+	var applied map[int64]*appliedMigration
+	if err := WithTx(ctx, nil, func(ctx context.Context) error {
+		if err := createMigrationTable(ctx); err != nil {
+			return err
+		}
+		var err error
+		applied, err = loadAppliedMigrations(ctx)
+		return err
+	}); err != nil {
+		return err
+	}
+	return fn(ctx, applied)
+}
+
+// splitStatements 将脚本按照分号拆分为多条sql，跳过字符串、带引号的标识符、注释和PostgreSQL美元符号引用的字符串中的分号。
+// 只包含空白和注释的sql会被丢弃。
+func splitStatements(d DbDialect, script string) []string {
+	var result []string
+	add := func(stmt string) {
+		if s := strings.TrimSpace(stmt); s != "" && strings.TrimSpace(stripComments(s)) != "" {
+			result = append(result, s)
+		}
+	}
+
+	last := 0
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(script, i, d == DialectMySQL && c != '`')
+		case c == '-' && i+1 < len(script) && script[i+1] == '-':
+			if j := strings.IndexByte(script[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(script)
+			}
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			if j := strings.Index(script[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(script)
+			}
+		case c == '$' && d == DialectPostgres:
+			i = skipDollarQuoted(script, i)
+		case c == ';':
+			add(script[last:i])
+			last = i + 1
+		}
+	}
+	if last < len(script) {
+		add(script[last:])
+	}
+	return result
+}
+
+// stripComments 去掉sql中的行注释和块注释，跳过字符串和带引号的标识符中的注释符号。
+// 行注释保留其后的换行，块注释替换为一个空格，避免前后的单词连在一起。
+// 除了判断sql是否为空，isReadOnlySql和SqlBuilder.mainTable也依赖它跳过注释中的关键字。
+func stripComments(s string) string {
+	var sb strings.Builder
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(s, i, false)
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			sb.WriteString(s[last:i])
+			if j := strings.IndexByte(s[i:], '\n'); j >= 0 {
+				i += j - 1
+			} else {
+				i = len(s)
+			}
+			last = i + 1
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			sb.WriteString(s[last:i] + " ")
+			if j := strings.Index(s[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(s)
+			}
+			last = i + 1
+		}
+	}
+	if last < len(s) {
+		sb.WriteString(s[last:])
+	}
+	return sb.String()
+}
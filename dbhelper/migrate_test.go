@@ -0,0 +1,115 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment; not split
+CREATE TABLE a (s VARCHAR(10) DEFAULT 'x;y');
+/* block; */ INSERT INTO a VALUES ('it''s');
+CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;
+-- trailing comment
+`
+	want := []string{
+		"-- comment; not split\nCREATE TABLE a (s VARCHAR(10) DEFAULT 'x;y')",
+		"/* block; */ INSERT INTO a VALUES ('it''s')",
+		"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql",
+	}
+	if got := splitStatements(DialectPostgres, script); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements() => %q, want %q", got, want)
+	}
+
+	q := "SELECT id -- FROM user\nFROM/* FOR UPDATE */doc WHERE name = '--x' /* unterminated"
+	if got, want := stripComments(q), "SELECT id \nFROM doc WHERE name = '--x'  "; got != want {
+		t.Errorf("stripComments() => %q, want %q", got, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.TODO()
+	fsys := fstest.MapFS{
+		"001_create_book.up.sql":   {Data: []byte("CREATE TABLE book (id INTEGER PRIMARY KEY, title VARCHAR(50));\nCREATE INDEX idx_book_title ON book (title);")},
+		"001_create_book.down.sql": {Data: []byte("DROP TABLE book;")},
+		"002_add_author.up.sql":    {Data: []byte("ALTER TABLE book ADD COLUMN author VARCHAR(50);")},
+		"002_add_author.down.sql":  {Data: []byte("ALTER TABLE book DROP COLUMN author;")},
+		"README.md":                {Data: []byte("ignored")},
+	}
+
+	if n, err := Migrate(ctx, fsys); err != nil || n != 2 {
+		t.Fatalf("Migrate() => %d %v, want 2", n, err)
+	}
+	if n, err := Migrate(ctx, fsys); err != nil || n != 0 {
+		t.Fatalf("Migrate(again) => %d %v, want 0", n, err)
+	}
+	MustExec[int](ctx, "INSERT INTO book (title, author) VALUES (:1, :2)", "Go", "Rob")
+
+	if st, err := Status(ctx, fsys); err != nil {
+		t.Fatal(err)
+	} else if len(st) != 2 || !st[0].Applied || !st[1].Applied || st[1].Name != "add_author" || st[1].AppliedAt.IsZero() {
+		t.Errorf("Status() => %+v", st)
+	}
+
+	for _, n := range []int{0, -1} {
+		if c, err := Rollback(ctx, fsys, n); err != nil || c != 0 {
+			t.Errorf("Rollback(%d) => %d %v, want 0", n, c, err)
+		}
+	}
+	if n, err := Rollback(ctx, fsys, 1); err != nil || n != 1 {
+		t.Fatalf("Rollback(1) => %d %v, want 1", n, err)
+	}
+	if st, _ := Status(ctx, fsys); !st[0].Applied || st[1].Applied {
+		t.Errorf("Status(after rollback) => %+v %+v", st[0], st[1])
+	}
+
+	// 修改已执行的迁移文件。
+	fsys["001_create_book.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE book (id INTEGER PRIMARY KEY);")}
+	if _, err := Migrate(ctx, fsys); !errors.Is(err, ErrMigrationChecksum) {
+		t.Errorf("Migrate(modified) => %v, want ErrMigrationChecksum", err)
+	}
+
+	// 失败的迁移被回滚，之后的迁移不会执行。
+	delete(fsys, "001_create_book.down.sql")
+	fsys["001_create_book.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE book (id INTEGER PRIMARY KEY, title VARCHAR(50));\nCREATE INDEX idx_book_title ON book (title);")}
+	fsys["003_bad.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE tmp (id INTEGER); INSERT INTO no_such_table VALUES (1);")}
+	if n, err := Migrate(ctx, fsys); err == nil || n != 1 {
+		t.Errorf("Migrate(bad) => %d %v, want 1 and error", n, err)
+	} else if n := MustQuery[int](ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'tmp'"); n != 0 {
+		t.Errorf("table tmp exists after failed migration")
+	}
+
+	if _, err := Rollback(ctx, fsys, 5); !errors.Is(err, ErrMigrationNoDown) {
+		t.Errorf("Rollback(no down) => %v, want ErrMigrationNoDown", err)
+	}
+}
+
+func TestMigrationLockConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+
+	// 测试的连接池只有一个连接，持有锁的连接之外开始事务会死锁。
+	ds := GetDataSource(DefaultDataSource)
+	conn, err := ds.DB().Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var applied map[int64]*appliedMigration
+	if err := runLocked(withConn(ctx, ds, conn), func(ctx context.Context, a map[int64]*appliedMigration) error {
+		applied = a
+		return WithTx(ctx, nil, func(ctx context.Context) error {
+			_, err := Query[int](ctx, "SELECT COUNT(*) FROM "+MigrationTable)
+			return err
+		})
+	}); err != nil {
+		t.Fatal(err)
+	} else if applied == nil {
+		t.Errorf("runLocked() => applied = nil")
+	}
+}
@@ -0,0 +1,68 @@
+package dbhelper
+
+import (
+	"context"
+)
+
+// MustExec 与Exec相同，但是出错时panic。
+func MustExec[T int | int8 | int16 | int32 | int64](ctx context.Context, query string, args ...any) T {
+	if r, err := Exec[T](ctx, query, args...); err != nil {
+		panic(err)
+	} else {
+		return r
+	}
+}
+
+// MustExecLastInsertId 与ExecLastInsertId相同，但是出错时panic。
+func MustExecLastInsertId[T int | int8 | int16 | int32 | int64](ctx context.Context, query string, args ...any) T {
+	if r, err := ExecLastInsertId[T](ctx, query, args...); err != nil {
+		panic(err)
+	} else {
+		return r
+	}
+}
+
+// MustQuery 与Query相同，但是出错时panic。
+func MustQuery[T any](ctx context.Context, query string, args ...any) T {
+	if r, err := Query[T](ctx, query, args...); err != nil {
+		panic(err)
+	} else {
+		return r
+	}
+}
+
+// MustQueryMaybe 与QueryMaybe相同，但是出错时panic。
+func MustQueryMaybe[T any](ctx context.Context, query string, args ...any) (T, bool) {
+	if r, found, err := QueryMaybe[T](ctx, query, args...); err != nil {
+		panic(err)
+	} else {
+		return r, found
+	}
+}
+
+// MustQueryObj 与QueryObj相同，但是出错时panic。
+func MustQueryObj[T any](ctx context.Context, query string, rh RowHandler[T], args ...any) *T {
+	if r, err := QueryObj[T](ctx, query, rh, args...); err != nil {
+		panic(err)
+	} else {
+		return r
+	}
+}
+
+// MustQueryList 与QueryList相同，但是出错时panic。
+func MustQueryList[T any](ctx context.Context, query string, args ...any) []T {
+	if r, err := QueryList[T](ctx, query, args...); err != nil {
+		panic(err)
+	} else {
+		return r
+	}
+}
+
+// MustQueryObjList 与QueryObjList相同，但是出错时panic。
+func MustQueryObjList[T any](ctx context.Context, query string, rh RowHandler[T], args ...any) []*T {
+	if r, err := QueryObjList[T](ctx, query, rh, args...); err != nil {
+		panic(err)
+	} else {
+		return r
+	}
+}
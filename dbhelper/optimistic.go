@@ -0,0 +1,133 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrStaleObject 表示乐观锁检查失败，即记录已经被其它事务修改或者删除。
+	ErrStaleObject = errors.New("stale object")
+	// ErrNoLockClause 表示使用乐观锁的SqlBuilder没有通过Set和Where构造SET和WHERE子句。
+	ErrNoLockClause = errors.New("optimistic lock requires SET and WHERE clauses")
+)
+
+// optimisticLock 表示乐观锁使用的列及其当前值。
+type optimisticLock struct {
+	column  string
+	current any
+	next    any // 为nil时表示版本号列，更新为column + 1。
+}
+
+// set 获取更新乐观锁列的表达式和参数，placeholder生成参数的占位符。
+func (l *optimisticLock) set(column string, placeholder string) (string, []any) {
+	if l.next == nil {
+		return column + " = " + column + " + 1", nil
+	}
+	return column + " = " + placeholder, []any{l.next}
+}
+
+// newTimestampLock 创建时间戳列的乐观锁，next为零值时使用当前时间。
+func newTimestampLock(column string, current, next time.Time) *optimisticLock {
+	if next.IsZero() {
+		next = time.Now()
+	}
+	return &optimisticLock{column: column, current: current, next: next}
+}
+
+// OptimisticVersion 使用整数版本号列实现乐观锁，只用于ExecOptimistic。
+// 执行时在SET子句中追加column = column + 1，在WHERE子句中追加column = current。
+func (b *SqlBuilder) OptimisticVersion(column string, current int64) *SqlBuilder {
+	b.lock = &optimisticLock{column: column, current: current}
+	return b
+}
+
+// OptimisticTimestamp 使用时间戳列（例如updated_at）实现乐观锁，只用于ExecOptimistic。
+// 执行时在SET子句中追加column = next，在WHERE子句中追加column = current，next为零值时使用当前时间。
+// current必须是从数据库中读出的原值；列的精度低于next时，同一时刻的两次更新可能无法区分。
+func (b *SqlBuilder) OptimisticTimestamp(column string, current, next time.Time) *SqlBuilder {
+	b.lock = newTimestampLock(column, current, next)
+	return b
+}
+
+// optimisticSql 生成带有乐观锁条件的sql，nargs是调用者传入的参数个数，乐观锁的参数从:nargs+1开始编号。
+func (b *SqlBuilder) optimisticSql(nargs int) (string, []any, error) {
+	if b.lock == nil {
+		return b.String(), nil, nil
+	} else if b.setAt == 0 || b.whereAt == 0 {
+		return "", nil, ErrNoLockClause
+	}
+
+	texts := append([]string(nil), b.texts...)
+	set, args := b.lock.set(b.lock.column, ":"+strconv.Itoa(nargs+1))
+	texts[b.setAt-1] += ",\n  " + set
+
+	args = append(args, b.lock.current)
+	texts[b.whereAt-1] = andWhere(texts[b.whereAt-1], b.lock.column+" = :"+strconv.Itoa(nargs+len(args)))
+
+	return strings.Join(texts, "\n"), args, nil
+}
+
+// OptimisticVersion 使用整数版本号列实现乐观锁，参见SqlBuilder.OptimisticVersion。
+func (b *UpdateBuilder) OptimisticVersion(column string, current int64) *UpdateBuilder {
+	b.lock = &optimisticLock{column: column, current: current}
+	return b
+}
+
+// OptimisticTimestamp 使用时间戳列实现乐观锁，参见SqlBuilder.OptimisticTimestamp。
+func (b *UpdateBuilder) OptimisticTimestamp(column string, current, next time.Time) *UpdateBuilder {
+	b.lock = newTimestampLock(column, current, next)
+	return b
+}
+
+// lockFragments 获取乐观锁的SET片段和WHERE条件。
+func (b *UpdateBuilder) lockFragments() ([]fragment, []fragment) {
+	if b.lock == nil {
+		return b.sets, b.where
+	}
+
+	column := b.dialect.QuoteIdent(b.lock.column)
+	set, args := b.lock.set(column, "?")
+	sets := append(b.sets[:len(b.sets):len(b.sets)], fragment{sql: set, args: args})
+
+	return sets, andConditions(b.where, fragment{joint: "AND", sql: column + " = ?", args: []any{b.lock.current}})
+}
+
+// ExecOptimistic 执行使用OptimisticVersion或者OptimisticTimestamp设置了乐观锁的UPDATE语句，
+// args是SqlBuilder中的参数，作用域和乐观锁的参数自动追加在其后，因此只能使用位置参数，参见SqlBuilder.BuildContext。
+// 没有更新任何记录时返回ErrStaleObject。违反外键约束时总是返回错误，以免被误认为乐观锁检查失败。
+// 在MySQL上，受影响的行数不包括值没有变化的记录，使用时间戳列时应当保证next与current不同。
+func ExecOptimistic(ctx context.Context, b *SqlBuilder, args ...any) error {
+	query, args, err := b.BuildContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	_, err = execExpectRows(ctx, query, args)
+	return err
+}
+
+// ExecUpdate 执行UpdateBuilder构造的UPDATE语句并返回受影响的行数，同时添加RegisterScope注册的作用域条件。
+// 设置了乐观锁时，没有更新任何记录返回ErrStaleObject，违反外键约束时总是返回错误。
+func ExecUpdate(ctx context.Context, b *UpdateBuilder) (int64, error) {
+	query, args, err := b.BuildContext(ctx)
+	if err != nil {
+		return 0, err
+	} else if b.lock != nil {
+		return execExpectRows(ctx, query, args)
+	}
+	return Exec[int64](ctx, query, args...)
+}
+
+// execExpectRows 执行sql，没有更新任何记录时返回ErrStaleObject。
+func execExpectRows(ctx context.Context, query string, args []any) (int64, error) {
+	if n, err := Exec[int64](ReportForeignKeyViolation(ctx, true), query, args...); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrStaleObject
+	} else {
+		return n, nil
+	}
+}
@@ -0,0 +1,74 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptimisticSql(t *testing.T) {
+	b := NewSqlBuilder("UPDATE item").
+		Set().Append("name = :1").End().
+		Where().Append("id = :2 OR code = :3").End().
+		OptimisticVersion("version", 3)
+	q, args, err := b.optimisticSql(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "UPDATE item\nSET\n  name = :1,\n  version = version + 1\nWHERE\n  (id = :2 OR code = :3)\n  AND version = :4"
+	if q != want || !reflect.DeepEqual(args, []any{int64(3)}) {
+		t.Errorf("optimisticSql() => %q %v, want %q", q, args, want)
+	}
+
+	if _, _, err := NewSqlBuilder("UPDATE item SET name = :1").OptimisticVersion("version", 1).optimisticSql(1); !errors.Is(err, ErrNoLockClause) {
+		t.Errorf("optimisticSql(no SET) => %v, want ErrNoLockClause", err)
+	}
+
+	now := time.Now()
+	q, args, err = NewUpdateBuilder("item").
+		Set("name", "a").
+		Where().And("id = ?", 1).Or("code = ?", "c").End().
+		OptimisticTimestamp("updated_at", now, now.Add(time.Second)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "UPDATE \"item\"\nSET\n  \"name\" = ?,\n  \"updated_at\" = ?\nWHERE\n  (id = ?\n  OR code = ?)\n  AND \"updated_at\" = ?"
+	if q != want || !reflect.DeepEqual(args, []any{"a", now.Add(time.Second), 1, "c", now}) {
+		t.Errorf("Build() => %q %v, want %q", q, args, want)
+	}
+}
+
+func TestExecOptimistic(t *testing.T) {
+	ctx := context.TODO()
+	MustExec[int](ctx, "CREATE TABLE item (id INTEGER PRIMARY KEY, name VARCHAR(50), version INTEGER NOT NULL DEFAULT 0)")
+	defer MustExec[int](ctx, "DROP TABLE item")
+	MustExec[int](ctx, "INSERT INTO item (id, name) VALUES (1, 'a')")
+
+	update := func(name string, version int64) error {
+		b := NewSqlBuilder("UPDATE item").
+			Set().Append("name = :1").End().
+			Where().Append("id = :2").End().
+			OptimisticVersion("version", version)
+		return ExecOptimistic(ctx, b, name, 1)
+	}
+	if err := update("b", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := update("c", 0); !errors.Is(err, ErrStaleObject) {
+		t.Errorf("ExecOptimistic(stale) => %v, want ErrStaleObject", err)
+	}
+	if v := MustQuery[int](ctx, "SELECT version FROM item WHERE id = 1"); v != 1 {
+		t.Errorf("version => %d, want 1", v)
+	}
+
+	b := NewUpdateBuilder("item").Set("name", "d").Where().And("id = ?", 1).End().OptimisticVersion("version", 1)
+	if n, err := ExecUpdate(ctx, b); err != nil || n != 1 {
+		t.Errorf("ExecUpdate() => %d, %v, want 1", n, err)
+	}
+	if _, err := ExecUpdate(ctx, b); !errors.Is(err, ErrStaleObject) {
+		t.Errorf("ExecUpdate(stale) => %v, want ErrStaleObject", err)
+	}
+}
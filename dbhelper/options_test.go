@@ -0,0 +1,72 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySqlOptions(t *testing.T) {
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = "db:3306"
+	cfg.DBName = "app"
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+
+	o := newOptions([]Option{WithCharset("utf8mb4"), WithTimeZone(loc), WithReadTimeout(5 * time.Second), WithParam("interpolateParams", "true"), WithTLS("verify-full", "")})
+	cfg, err := o.applyMySql(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Params["charset"] != "utf8mb4" || cfg.Loc.String() != loc.String() || cfg.ReadTimeout != 5*time.Second || !cfg.InterpolateParams {
+		t.Errorf("applyMySql() => %s", cfg.FormatDSN())
+	}
+	if cfg.TLS == nil || cfg.TLS.ServerName != "db" || cfg.TLS.InsecureSkipVerify {
+		t.Errorf("applyMySql() => tls %+v", cfg.TLS)
+	}
+	if o.maxIdleConns != 2 || o.connMaxLifetime != 300*time.Second {
+		t.Errorf("newOptions() => %+v, want default pool", o)
+	}
+}
+
+func TestPostgresOptions(t *testing.T) {
+	o := newOptions([]Option{WithTLS("verify-ca", "/etc/ca.pem"), WithConnectTimeout(1500 * time.Millisecond), WithTimeZone(time.UTC), WithParam("application_name", "it's")})
+	dsn := formatPostgresDSN(o.postgresParams())
+	want := `application_name='it\'s' connect_timeout='1' sslmode='verify-ca' sslrootcert='/etc/ca.pem' timezone='UTC'`
+	if dsn != want {
+		t.Errorf("formatPostgresDSN() => %s, want %s", dsn, want)
+	}
+}
+
+func TestInitDbFromURL(t *testing.T) {
+	t.Setenv("TEST_SQLITE_PATH", ":memory:")
+
+	if err := InitDataSourceFromURL("url", "sqlite:${TEST_SQLITE_PATH}?max_idle_conns=5&_busy_timeout=100"); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDataSource("url")
+
+	ds := GetDataSource("url")
+	if ds == nil || ds.Dialect() != DialectSQLite || ds.DB().Stats().MaxOpenConnections != 1 {
+		t.Fatalf("InitDataSourceFromURL() => %+v", ds)
+	}
+	if n := MustQuery[int](WithDataSource(context.TODO(), "url"), "PRAGMA busy_timeout"); n != 100 {
+		t.Errorf("PRAGMA busy_timeout => %d, want 100", n)
+	}
+	if n := MustQuery[int](WithDataSource(context.TODO(), "url"), "PRAGMA foreign_keys"); n != 1 {
+		t.Errorf("PRAGMA foreign_keys => %d, want 1", n)
+	}
+
+	for _, u := range []string{"oracle://db/app", "mysql://db/app?max_open_conns=x"} {
+		if err := InitDataSourceFromURL("url2", u); !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("InitDataSourceFromURL(%s) => %v, want ErrUnsupportedURL", u, err)
+		}
+	}
+	if err := InitDataSourceFromURL("url2", "mysql://u:p@127.0.0.1:1/app?timeout=100ms"); err == nil || strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("InitDataSourceFromURL(mysql) => %v, want connection error", err)
+	}
+}
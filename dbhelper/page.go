@@ -0,0 +1,96 @@
+package dbhelper
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Lord-Haart/go-common/utils"
+)
+
+// seek 表示键集分页的条件。
+type seek struct {
+	column string
+	after  any
+	desc   bool
+}
+
+// QueryPage 分页查询对象列表，返回填充好的分页结果。
+//
+// 查询总数的sql由b去掉ORDER BY和LIMIT子句之后包装为子查询得到，即SELECT COUNT(*) FROM (...)，
+// 因此b中可以包含GROUP BY和DISTINCT；查询当前页时使用pr替换b中的LIMIT子句。
+// 如果上下文中有事务，那么两条sql都在该事务中执行。pr为nil或者PageSize小于等于0时查询全部记录。
+// 与SqlBuilder.BuildContext相同，自动添加RegisterScope注册的作用域条件。
+//
+// 如果调用了b.Seek，那么使用键集分页：以上一页最后一条记录的排序列的值作为条件，不再使用OFFSET，
+// 适用于翻到很深的页；此时pr.PageNumber只用于填充结果，总数仍然是全部记录的个数。
+func QueryPage[T any](ctx context.Context, b *SqlBuilder, rh RowHandler[T], pr *utils.PageRequest, args ...any) (utils.Page[*T], error) {
+	size, number := 0, 0
+	if pr != nil && pr.PageSize > 0 {
+		size, number = pr.PageSize, pr.PageNumber
+	}
+
+	b, args, err := b.scoped(ctx, args)
+	if err != nil {
+		return utils.Page[*T]{}, err
+	}
+
+	// 先改写参数占位符，以便可以在sql的末尾追加键集分页的参数。
+	d := DialectOf(ctx)
+	base, oargs, err := rewriteSql(d, b.baseSql(), args)
+	if err != nil {
+		return utils.Page[*T]{}, err
+	}
+
+	query := base
+	if s := b.seek; s != nil {
+		col := "t_page." + d.QuoteIdent(s.column)
+		query = "SELECT * FROM (\n" + base + "\n) t_page"
+		if s.after != nil {
+			op := " > "
+			if s.desc {
+				op = " < "
+			}
+			oargs = append(append([]any(nil), oargs...), s.after)
+			if d == DialectPostgres {
+				query += "\nWHERE " + col + op + "$" + strconv.Itoa(len(oargs))
+			} else {
+				query += "\nWHERE " + col + op + "?"
+			}
+		}
+		query += "\nORDER BY " + col
+		if s.desc {
+			query += " DESC"
+		}
+		if size > 0 {
+			query += "\nLIMIT " + strconv.Itoa(size)
+		}
+	} else {
+		if order := b.orderSql(); order != "" {
+			query += "\n" + order
+		}
+		if size > 0 {
+			query += "\nLIMIT " + strconv.Itoa(size) + " OFFSET " + strconv.Itoa(number*size)
+		}
+	}
+
+	content, err := QueryObjList(ctx, query, rh, oargs...)
+	if err != nil {
+		return utils.Page[*T]{}, err
+	}
+
+	// 不是最后一页或者无法根据偏移量推算时才需要查询总数。
+	total := int64(len(content))
+	if size > 0 && (b.seek != nil || len(content) == 0 && number > 0 || len(content) == size) {
+		countArgs := oargs
+		if b.seek != nil && b.seek.after != nil {
+			countArgs = oargs[:len(oargs)-1]
+		}
+		if total, err = Query[int64](ctx, "SELECT COUNT(*) FROM (\n"+base+"\n) t_count", countArgs...); err != nil {
+			return utils.Page[*T]{}, err
+		}
+	} else if size > 0 {
+		total += int64(number * size)
+	}
+
+	return utils.MakePage(number, size, total, content), nil
+}
@@ -0,0 +1,54 @@
+package dbhelper
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Lord-Haart/go-common/utils"
+)
+
+func TestQueryPage(t *testing.T) {
+	ctx := context.TODO()
+	for i := 0; i < 5; i++ {
+		MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "page"+string(rune('a'+i)))
+	}
+
+	newBuilder := func() *SqlBuilder {
+		b := NewSqlBuilder("SELECT id, user_name FROM user")
+		b.Where().Append("user_name LIKE :1").End()
+		return b.OrderBy("user_name DESC").Limit(0, 100)
+	}
+
+	p, err := QueryPage[userPo](ctx, newBuilder(), StructMapper[userPo]{}, &utils.PageRequest{PageNumber: 1, PageSize: 2}, "page%")
+	if err != nil {
+		t.Fatal(err)
+	} else if p.TotalElements != 5 || p.TotalPages != 3 || len(p.Content) != 2 || p.Content[0].UserName != "pagec" {
+		t.Errorf("QueryPage() => %+v", p)
+	}
+
+	// 最后一页不需要查询总数。
+	if p, err := QueryPage[userPo](ctx, newBuilder(), StructMapper[userPo]{}, &utils.PageRequest{PageNumber: 2, PageSize: 2}, "page%"); err != nil {
+		t.Fatal(err)
+	} else if p.TotalElements != 5 || len(p.Content) != 1 || p.Content[0].UserName != "pagea" {
+		t.Errorf("QueryPage(last) => %+v", p)
+	}
+
+	var after any
+	var names []string
+	for i := 0; i < 3; i++ {
+		b := newBuilder().Seek("id", after, false)
+		p, err := QueryPage[userPo](ctx, b, StructMapper[userPo]{}, &utils.PageRequest{PageNumber: i, PageSize: 2}, "page%")
+		if err != nil {
+			t.Fatal(err)
+		} else if p.TotalElements != 5 {
+			t.Errorf("QueryPage(seek) => %+v", p)
+		}
+		for _, u := range p.Content {
+			names = append(names, u.UserName)
+			after = u.Id
+		}
+	}
+	if len(names) != 5 || names[0] != "pagea" || names[4] != "pagee" {
+		t.Errorf("QueryPage(seek) => %v", names)
+	}
+}
@@ -0,0 +1,293 @@
+package dbhelper
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// placeholder 表示sql中的一个参数占位符，即query[start:end]。
+type placeholder struct {
+	start int
+	end   int
+	index int    // 位置参数的序号，从1开始；命名参数为0。
+	name  string // 命名参数的名称。
+}
+
+var (
+	valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+	inPrefixPattern = regexp.MustCompile(`(?i)(\bNOT\s+)?\bIN\s*\(\s*$`)
+	inSuffixPattern = regexp.MustCompile(`^\s*\)`)
+)
+
+// rewriteSql 将sql中的参数占位符改写为方言对应的占位符，并按照占位符出现的顺序排列参数。
+//
+// 支持两种占位符，但是不能在同一条sql中混用：
+//  1. 位置参数:1到:99，对应args中的第n个参数；
+//  2. 命名参数:name，此时args必须只包含一个map[string]T或者结构体（及其指针），
+//     结构体字段与名称的匹配规则与StructMapper相同，例如:userId可以匹配`db:"user_id"`或者字段UserId。
+//
+// 字符串、带引号的标识符、注释中的内容以及PostgreSQL的::类型转换不会被视为占位符。
+// 同一个参数出现多次时，PostgreSQL复用同一个$n，其它方言重复传递该参数。
+// 如果sql中没有这两种占位符，那么认为sql已经使用了方言对应的占位符，参数原样返回。
+//
+// 参数是切片（[]byte和实现了driver.Valuer的类型除外）时展开为多个占位符，用于IN子句，例如
+// "id IN (:1)"和[]int64{1, 2}改写为"id IN (?,?)"；空切片改写为不返回任何记录的子查询，
+// 因此IN的结果为false、NOT IN的结果为true。PostgreSQL上的"IN (:1)"和"NOT IN (:1)"分别改写为"= ANY($1)"和"<> ALL($1)"，
+// 并使用pq.Array传递整个切片，这样无论切片多长都只需要一个参数，语句也可以被缓存。
+// 改写后的参数个数超过方言的上限时返回ErrTooManyArgs。
+func rewriteSql(dialect DbDialect, query string, args []any) (string, []any, error) {
+	phs, err := scanPlaceholders(dialect, query)
+	if err != nil {
+		return "", nil, err
+	} else if len(phs) == 0 {
+		return query, args, nil
+	}
+
+	var lookup func(ph placeholder) (any, error)
+	if phs[0].name != "" {
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("%w: named placeholders require exactly one map or struct argument", ErrIllegalPlaceholder)
+		} else if lookup, err = namedArgLookup(args[0]); err != nil {
+			return "", nil, err
+		}
+	} else {
+		lookup = func(ph placeholder) (any, error) {
+			if ph.index > len(args) {
+				return nil, ErrMissingArg{Index: ph.index}
+			}
+			return args[ph.index-1], nil
+		}
+	}
+
+	var sb strings.Builder
+	oargs := make([]any, 0, len(phs))
+	seen := make(map[string]int) // 参数 => $n，仅用于PostgreSQL的非切片参数。
+	bind := func(v any) string {
+		oargs = append(oargs, v)
+		if dialect == DialectPostgres {
+			return "$" + strconv.Itoa(len(oargs))
+		}
+		return "?"
+	}
+
+	last := 0
+	for _, ph := range phs {
+		if (ph.name != "") != (phs[0].name != "") {
+			return "", nil, fmt.Errorf("%w: cannot mix named and positional placeholders", ErrIllegalPlaceholder)
+		}
+
+		prefix := query[last:ph.start]
+		last = ph.end
+
+		key := query[ph.start:ph.end]
+		if n, ok := seen[key]; ok {
+			sb.WriteString(prefix + "$" + strconv.Itoa(n))
+			continue
+		}
+
+		v, err := lookup(ph)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if sv, ok := sliceArg(v); !ok {
+			sb.WriteString(prefix + bind(v))
+			if dialect == DialectPostgres {
+				seen[key] = len(oargs)
+			}
+		} else if m, n := inPrefixPattern.FindStringSubmatchIndex(prefix), inSuffixPattern.FindStringIndex(query[ph.end:]); dialect == DialectPostgres && m != nil && n != nil {
+			op := "= ANY("
+			if m[2] >= 0 {
+				op = "<> ALL("
+			}
+			sb.WriteString(prefix[:m[0]] + op + bind(pq.Array(v)) + ")")
+			last = ph.end + n[1]
+		} else if sv.Len() == 0 {
+			sb.WriteString(prefix + dialect.emptySubquery())
+		} else {
+			sb.WriteString(prefix)
+			for i := 0; i < sv.Len(); i++ {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(bind(sv.Index(i).Interface()))
+			}
+		}
+	}
+	sb.WriteString(query[last:])
+
+	if limit := dialect.maxPlaceholders(); len(oargs) > limit {
+		return "", nil, fmt.Errorf("%w: %d args, at most %d", ErrTooManyArgs, len(oargs), limit)
+	}
+	return sb.String(), oargs, nil
+}
+
+// sliceArg 判断参数是否是需要展开的切片。
+func sliceArg(v any) (reflect.Value, bool) {
+	sv := reflect.ValueOf(v)
+	if sv.Kind() != reflect.Slice || sv.Type().Elem().Kind() == reflect.Uint8 || sv.Type().Implements(valuerType) {
+		return sv, false
+	}
+	return sv, true
+}
+
+// scanPlaceholders 找出sql中所有的参数占位符，跳过字符串、带引号的标识符、注释和::类型转换。
+func scanPlaceholders(dialect DbDialect, query string) ([]placeholder, error) {
+	var result []placeholder
+	n := len(query)
+	for i := 0; i < n; i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i, dialect == DialectMySQL && c != '`')
+		case c == '-' && i+1 < n && query[i+1] == '-':
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = n
+			}
+		case c == '/' && i+1 < n && query[i+1] == '*':
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = n
+			}
+		case c == '$' && dialect == DialectPostgres:
+			i = skipDollarQuoted(query, i)
+		case c == ':' && i+1 < n:
+			d := query[i+1]
+			if d == ':' {
+				// PostgreSQL的类型转换，例如'1'::int。
+				i++
+			} else if d >= '1' && d <= '9' {
+				j := i + 2
+				for j < n && isDigit(query[j]) {
+					j++
+				}
+				if j-i > 3 {
+					return nil, fmt.Errorf("%w: %#v", ErrIllegalPlaceholder, query[i:j])
+				}
+				index, _ := strconv.Atoi(query[i+1 : j])
+				result = append(result, placeholder{start: i, end: j, index: index})
+				i = j - 1
+			} else if isIdentStart(d) && (i == 0 || !isIdentPart(query[i-1])) {
+				j := i + 2
+				for j < n && isIdentPart(query[j]) {
+					j++
+				}
+				result = append(result, placeholder{start: i, end: j, name: query[i+1 : j]})
+				i = j - 1
+			}
+		}
+	}
+	return result, nil
+}
+
+// skipQuoted 跳过从i开始的字符串或者带引号的标识符，返回结束引号的位置。
+// 两个连续的引号表示引号本身；backslash为true时反斜杠转义下一个字符。
+func skipQuoted(query string, i int, backslash bool) int {
+	q := query[i]
+	for j := i + 1; j < len(query); j++ {
+		if backslash && query[j] == '\\' {
+			j++
+		} else if query[j] == q {
+			if j+1 < len(query) && query[j+1] == q {
+				j++
+			} else {
+				return j
+			}
+		}
+	}
+	return len(query)
+}
+
+// skipDollarQuoted 跳过从i开始的PostgreSQL美元符号引用的字符串，例如$$text$$或者$tag$text$tag$。
+// 如果i处不是这种字符串的开始，那么返回i。
+func skipDollarQuoted(query string, i int) int {
+	j := i + 1
+	for j < len(query) && isIdentPart(query[j]) && !isDigit(query[j]) {
+		j++
+	}
+	if j >= len(query) || query[j] != '$' {
+		return i
+	}
+
+	tag := query[i : j+1]
+	if k := strings.Index(query[j+1:], tag); k >= 0 {
+		return j + k + len(tag)
+	}
+	return len(query)
+}
+
+// namedArgLookup 根据命名参数的来源创建查找函数，来源必须是key为字符串的map或者结构体（及其指针）。
+func namedArgLookup(arg any) (func(ph placeholder) (any, error), error) {
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Pointer && !v.IsNil() && !v.Type().Implements(valuerType) {
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+		return func(ph placeholder) (any, error) {
+			if mv := v.MapIndex(reflect.ValueOf(ph.name).Convert(v.Type().Key())); mv.IsValid() {
+				return mv.Interface(), nil
+			}
+			// 忽略大小写和下划线再匹配一次。
+			name := normalizeName(ph.name)
+			for it := v.MapRange(); it.Next(); {
+				if normalizeName(it.Key().String()) == name {
+					return it.Value().Interface(), nil
+				}
+			}
+			return nil, ErrMissingArg{Name: ph.name}
+		}, nil
+	} else if v.Kind() == reflect.Struct && !isLeafType(v.Type()) && !v.Type().Implements(valuerType) {
+		indexes := fieldIndexesOf(v.Type())
+		return func(ph placeholder) (any, error) {
+			if index, ok := indexes[strings.ToLower(ph.name)]; ok {
+				return fieldValue(v, index), nil
+			}
+			// `db`标签也忽略大小写和下划线再匹配一次。
+			name := normalizeName(ph.name)
+			for key, index := range indexes {
+				if normalizeName(key) == name {
+					return fieldValue(v, index), nil
+				}
+			}
+			return nil, ErrMissingArg{Name: ph.name}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: named placeholders require a map or struct argument, got %T", ErrIllegalPlaceholder, arg)
+}
+
+// fieldValue 获取嵌套字段的值，途经空指针时返回nil。
+func fieldValue(v reflect.Value, index []int) any {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v.Interface()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
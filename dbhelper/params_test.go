@@ -0,0 +1,88 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Lord-Haart/go-common/utils"
+	"github.com/lib/pq"
+)
+
+func TestRewriteSql(t *testing.T) {
+	type filter struct {
+		UserName string `db:"user_name"`
+		MinId    int
+	}
+
+	cases := []struct {
+		dialect   DbDialect
+		query     string
+		args      []any
+		wantQuery string
+		wantArgs  []any
+	}{
+		{DialectMySQL, "SELECT * FROM t WHERE a = :2 AND b = :1", []any{1, 2}, "SELECT * FROM t WHERE a = ? AND b = ?", []any{2, 1}},
+		{DialectPostgres, "SELECT * FROM t WHERE a = :1 OR b = :1", []any{1}, "SELECT * FROM t WHERE a = $1 OR b = $1", []any{1}},
+		{DialectMySQL, "SELECT * FROM t WHERE a = :userId OR b = :userId", []any{map[string]any{"userId": 1}}, "SELECT * FROM t WHERE a = ? OR b = ?", []any{1, 1}},
+		{DialectPostgres, "SELECT * FROM t WHERE user_name = :userName AND id > :min_id", []any{&filter{"admin", 3}}, "SELECT * FROM t WHERE user_name = $1 AND id > $2", []any{"admin", 3}},
+		{DialectPostgres, "SELECT '10:30', '::a', :v::text, $$:x$$ -- :y\nFROM t", []any{map[string]string{"v": "1"}}, "SELECT '10:30', '::a', $1::text, $$:x$$ -- :y\nFROM t", []any{"1"}},
+		{DialectMySQL, "SELECT 'it''s :a', \"\\\" :b\", `:c` FROM t WHERE a = :d /* :e */", []any{map[string]any{"d": 1}}, "SELECT 'it''s :a', \"\\\" :b\", `:c` FROM t WHERE a = ? /* :e */", []any{1}},
+		{DialectMySQL, "SELECT * FROM t WHERE a = ?", []any{1}, "SELECT * FROM t WHERE a = ?", []any{1}},
+		{DialectMySQL, "SELECT * FROM t WHERE id IN (:1) AND b = :2", []any{[]int64{1, 2, 3}, []byte("b")}, "SELECT * FROM t WHERE id IN (?,?,?) AND b = ?", []any{int64(1), int64(2), int64(3), []byte("b")}},
+		{DialectMySQL, "SELECT * FROM t WHERE id NOT IN (:ids)", []any{map[string]any{"ids": []int{}}}, "SELECT * FROM t WHERE id NOT IN (SELECT NULL FROM DUAL WHERE 1=0)", []any{}},
+		{DialectPostgres, "SELECT * FROM t WHERE id IN ( :1 ) AND name NOT IN (:2)", []any{[]int64{1, 2}, []string{"a"}}, "SELECT * FROM t WHERE id = ANY($1) AND name <> ALL($2)", []any{pq.Array([]int64{1, 2}), pq.Array([]string{"a"})}},
+	}
+
+	for _, c := range cases {
+		query, args, err := rewriteSql(c.dialect, c.query, c.args)
+		if err != nil {
+			t.Errorf("rewriteSql(%q) => %v", c.query, err)
+		} else if query != c.wantQuery || !reflect.DeepEqual(args, c.wantArgs) {
+			t.Errorf("rewriteSql(%q) => %q %v, want %q %v", c.query, query, args, c.wantQuery, c.wantArgs)
+		}
+	}
+
+	var me ErrMissingArg
+	if _, _, err := rewriteSql(DialectMySQL, "SELECT :name", []any{map[string]any{}}); !errors.As(err, &me) || me.Name != "name" {
+		t.Errorf("rewriteSql(missing name) => %v, want ErrMissingArg", err)
+	}
+	for _, q := range []string{"SELECT :a, :1", "SELECT :100"} {
+		if _, _, err := rewriteSql(DialectMySQL, q, []any{map[string]any{"a": 1}}); !errors.Is(err, ErrIllegalPlaceholder) {
+			t.Errorf("rewriteSql(%q) => %v, want ErrIllegalPlaceholder", q, err)
+		}
+	}
+	if _, _, err := rewriteSql(DialectMySQL, "SELECT :a", []any{1}); !errors.Is(err, ErrIllegalPlaceholder) {
+		t.Errorf("rewriteSql(scalar) => %v, want ErrIllegalPlaceholder", err)
+	}
+	if _, _, err := rewriteSql(DialectSQLite, "SELECT * FROM t WHERE id IN (:1)", []any{make([]int, 40000)}); !errors.Is(err, ErrTooManyArgs) {
+		t.Errorf("rewriteSql(large slice) => %v, want ErrTooManyArgs", err)
+	}
+}
+
+func TestNamedArgs(t *testing.T) {
+	ctx := context.TODO()
+
+	MustExec[int](ctx, "INSERT INTO user (user_name, nick_name) VALUES (:userName, :nick_name)", &userPo{UserName: "named1", Nick: utils.String{Valid: true, V: "Named"}})
+	MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:name)", map[string]string{"name": "named2"})
+	if n := MustQuery[string](ctx, "SELECT nick_name FROM user WHERE user_name = :name", map[string]any{"name": "named1"}); n != "Named" {
+		t.Errorf("Query(named) => %q, want Named", n)
+	}
+
+	if n := MustQuery[int](ctx, "SELECT COUNT(*) FROM user WHERE user_name IN (:1)", []string{"named1", "named2", "none"}); n != 2 {
+		t.Errorf("Query(in) => %d, want 2", n)
+	}
+	if n := MustQuery[int](ctx, "SELECT COUNT(*) FROM user WHERE user_name IN (:1)", []string{}); n != 0 {
+		t.Errorf("Query(in empty) => %d, want 0", n)
+	}
+	if n := MustQuery[int](ctx, "SELECT COUNT(*) FROM user WHERE user_name NOT IN (:1)", []string{}); n != MustQuery[int](ctx, "SELECT COUNT(*) FROM user") {
+		t.Errorf("Query(not in empty) => %d, want all", n)
+	}
+}
+
+func TestJoinInString(t *testing.T) {
+	if s := JoinInString([]string{"a", "b'); DROP TABLE user; --"}); s != "('a','b''); DROP TABLE user; --')" {
+		t.Errorf("JoinInString() => %s", s)
+	}
+}
@@ -0,0 +1,261 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaPolicy 表示从库的选择策略。
+type ReplicaPolicy int
+
+const (
+	// ReplicaRoundRobin 依次轮流使用健康的从库，这是默认的策略。
+	ReplicaRoundRobin ReplicaPolicy = iota
+	// ReplicaLeastConn 使用正在使用的连接数最少的健康从库。
+	ReplicaLeastConn
+)
+
+// ReplicaStatus 表示一个从库的状态。
+type ReplicaStatus struct {
+	Name      string    // 从库的名称。
+	Healthy   bool      // 是否处于可用状态。
+	LastErr   error     // 最近一次检查时发生的错误。
+	CheckedAt time.Time // 最近一次检查的时间，从未检查时为零值。
+}
+
+// replica 表示数据源的一个从库，每个从库都有自己的连接池和预编译语句缓存。
+type replica struct {
+	name    string
+	db      *sql.DB
+	stmts   *stmtCache
+	healthy atomic.Bool
+	status  atomic.Pointer[ReplicaStatus]
+}
+
+// primaryCtxKey 用于在上下文中记录是否强制使用主库的key。
+type primaryCtxKey struct{}
+
+var (
+	// ErrReplicaExists 表示数据源中已经存在同名的从库。
+	ErrReplicaExists = errors.New("replica already exists")
+
+	// lockingReadPattern 匹配加锁读，这种sql必须在主库上执行。
+	lockingReadPattern = regexp.MustCompile(`\bFOR\s+(NO\s+KEY\s+UPDATE|UPDATE|KEY\s+SHARE|SHARE)\b|\bLOCK\s+IN\s+SHARE\s+MODE\b|\bINTO\b`)
+	// sideEffectPattern 匹配常见的有副作用的函数，例如序列、咨询锁。
+	sideEffectPattern = regexp.MustCompile(`\b(NEXTVAL|SETVAL|GET_LOCK|RELEASE_LOCK|RELEASE_ALL_LOCKS|PG_(TRY_)?ADVISORY_(XACT_)?(UN)?LOCK(_SHARED|_ALL)?|LAST_INSERT_ID|SLEEP|PG_SLEEP)\s*\(`)
+	// writeKeywordPattern 匹配WITH语句中的数据修改语句。
+	writeKeywordPattern = regexp.MustCompile(`\b(INSERT|UPDATE|DELETE|MERGE)\b`)
+)
+
+// ForcePrimary 返回一个新的上下文，之后使用该上下文执行的查询都在主库上执行。
+// 刚写入的数据需要立即读取时，或者查询中调用了isReadOnlySql无法识别的有副作用的函数时，应当使用该上下文。
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+// isPrimaryForced 判断上下文是否要求使用主库。
+func isPrimaryForced(ctx context.Context) bool {
+	force, _ := ctx.Value(primaryCtxKey{}).(bool)
+	return force
+}
+
+// AddReplica 为数据源添加一个从库，name用于在日志和状态中区分从库。
+// 新添加的从库立即参与轮换，连接池由数据源负责关闭。
+func (ds *DataSource) AddReplica(name string, db *sql.DB) error {
+	ds.replicaLock.Lock()
+	defer ds.replicaLock.Unlock()
+
+	var list []*replica
+	if p := ds.replicas.Load(); p != nil {
+		list = *p
+	}
+	for _, r := range list {
+		if r.name == name {
+			return fmt.Errorf("%w: %q", ErrReplicaExists, name)
+		}
+	}
+
+	r := &replica{name: name, db: db, stmts: newStmtCache(ds.stmts.snapshot().Capacity)}
+	r.healthy.Store(true)
+	r.status.Store(&ReplicaStatus{Name: name, Healthy: true})
+
+	// 写时复制，选择从库时不需要加锁。
+	list = append(list[:len(list):len(list)], r)
+	ds.replicas.Store(&list)
+	return nil
+}
+
+// AddMySqlReplica 为数据源添加一个MySql从库，参数的含义与InitMySqlDataSource相同，addr被用作从库的名称。
+func (ds *DataSource) AddMySqlReplica(addr, username, password, dbname string, opts ...Option) error {
+	o := newOptions(opts)
+	return ds.addReplica(addr, o, func() (*sql.DB, error) {
+		return openMySqlDb(addr, username, password, dbname, o)
+	})
+}
+
+// AddPostgresReplica 为数据源添加一个PostgreSQL从库，参数的含义与InitPostgresDataSource相同，host:port被用作从库的名称。
+func (ds *DataSource) AddPostgresReplica(host string, port int, username, password, dbname string, opts ...Option) error {
+	o := newOptions(opts)
+	return ds.addReplica(host+":"+strconv.Itoa(port), o, func() (*sql.DB, error) {
+		return openPostgresDb(host, port, username, password, dbname, o)
+	})
+}
+
+// addReplica 创建并检查从库的连接池，成功后添加到数据源。
+func (ds *DataSource) addReplica(name string, o *dbOptions, open func() (*sql.DB, error)) error {
+	db, err := open()
+	if err != nil {
+		return err
+	} else if err := openPool(db, o); err != nil {
+		return err
+	} else if err := ds.AddReplica(name, db); err != nil {
+		db.Close()
+		return err
+	}
+	return nil
+}
+
+// SetReplicaPolicy 设置选择从库的策略，默认为ReplicaRoundRobin。应当在初始化数据源时设置。
+func (ds *DataSource) SetReplicaPolicy(policy ReplicaPolicy) { ds.replicaPolicy = policy }
+
+// Replicas 获取数据源所有从库的状态。
+func (ds *DataSource) Replicas() []ReplicaStatus {
+	list := ds.replicaList()
+	result := make([]ReplicaStatus, 0, len(list))
+	for _, r := range list {
+		result = append(result, *r.status.Load())
+	}
+	return result
+}
+
+// CheckReplicas 对所有从库执行Ping，失败的从库被移出轮换，恢复的从库重新加入轮换。
+// 返回仍然可用的从库的个数。
+func (ds *DataSource) CheckReplicas(ctx context.Context) int {
+	n := 0
+	for _, r := range ds.replicaList() {
+		err := r.db.PingContext(ctx)
+		r.healthy.Store(err == nil)
+		r.status.Store(&ReplicaStatus{Name: r.name, Healthy: err == nil, LastErr: err, CheckedAt: time.Now()})
+		if err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// StartReplicaHealthCheck 启动后台任务，每隔interval调用一次CheckReplicas，每次检查的超时时间也是interval。
+// 重复调用时先停止之前的任务。数据源被关闭时任务自动停止。
+func (ds *DataSource) StartReplicaHealthCheck(interval time.Duration) {
+	stop := make(chan struct{})
+
+	ds.replicaLock.Lock()
+	if ds.stopCheck != nil {
+		close(ds.stopCheck)
+	}
+	ds.stopCheck = stop
+	ds.replicaLock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				ds.CheckReplicas(ctx)
+				cancel()
+			}
+		}
+	}()
+}
+
+// closeReplicas 停止健康检查并关闭所有从库的连接池。
+func (ds *DataSource) closeReplicas() error {
+	ds.replicaLock.Lock()
+	defer ds.replicaLock.Unlock()
+
+	if ds.stopCheck != nil {
+		close(ds.stopCheck)
+		ds.stopCheck = nil
+	}
+
+	var errs []error
+	if p := ds.replicas.Swap(nil); p != nil {
+		for _, r := range *p {
+			r.stmts.resize(0)
+			errs = append(errs, r.db.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// replicaList 获取数据源的所有从库，返回的切片不能修改。
+func (ds *DataSource) replicaList() []*replica {
+	if p := ds.replicas.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// pickReplica 按照数据源的策略选择一个健康的从库，没有可用的从库时返回nil。
+func (ds *DataSource) pickReplica() *replica {
+	list := ds.replicaList()
+	if len(list) == 0 {
+		return nil
+	}
+
+	if ds.replicaPolicy == ReplicaLeastConn {
+		var best *replica
+		bestInUse := 0
+		for _, r := range list {
+			if !r.healthy.Load() {
+				continue
+			}
+			if inUse := r.db.Stats().InUse; best == nil || inUse < bestInUse {
+				best, bestInUse = r, inUse
+			}
+		}
+		return best
+	}
+
+	start := ds.replicaNext.Add(1)
+	for i := range list {
+		if r := list[(start+uint64(i))%uint64(len(list))]; r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// isReadOnlySql 判断sql是否可以在从库上执行。
+// 只有SELECT、SHOW、EXPLAIN等语句，并且不包含加锁读（FOR UPDATE、FOR SHARE等）、SELECT INTO
+// 和常见的有副作用的函数（nextval、GET_LOCK、pg_advisory_lock等）时才认为是只读的；无法判断时视为写语句。
+// 只能识别常见的函数，调用了其它有副作用的函数（例如自定义函数）时，调用者必须使用ForcePrimary。
+func isReadOnlySql(query string) bool {
+	q := strings.ToUpper(stripComments(query))
+	q = strings.TrimLeft(q, " \t\r\n(")
+
+	keyword := q
+	if i := strings.IndexFunc(q, func(r rune) bool { return !isIdentPart(byte(r)) }); i >= 0 {
+		keyword = q[:i]
+	}
+
+	if keyword == "SELECT" || keyword == "VALUES" {
+		return !lockingReadPattern.MatchString(q) && !sideEffectPattern.MatchString(q)
+	} else if keyword == "WITH" || keyword == "EXPLAIN" {
+		// EXPLAIN ANALYZE会真正执行语句。
+		return !lockingReadPattern.MatchString(q) && !sideEffectPattern.MatchString(q) && !writeKeywordPattern.MatchString(q)
+	} else {
+		return keyword == "SHOW" || keyword == "DESCRIBE" || keyword == "DESC"
+	}
+}
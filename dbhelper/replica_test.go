@@ -0,0 +1,101 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func openReplicaTestDb(t *testing.T, v string) *sql.DB {
+	db, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE node (v TEXT)"); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("INSERT INTO node (v) VALUES (?)", v); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestReplicaRouting(t *testing.T) {
+	ds := RegisterDataSource("rw", openReplicaTestDb(t, "p"), DialectSQLite)
+	defer CloseDataSource("rw")
+
+	r1 := openReplicaTestDb(t, "r1")
+	if err := ds.AddReplica("r1", r1); err != nil {
+		t.Fatal(err)
+	} else if err := ds.AddReplica("r2", openReplicaTestDb(t, "r2")); err != nil {
+		t.Fatal(err)
+	} else if err := ds.AddReplica("r2", r1); err == nil {
+		t.Errorf("AddReplica(r2) => nil, want ErrReplicaExists")
+	}
+
+	ctx := WithDataSource(context.TODO(), "rw")
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[MustQuery[string](ctx, "SELECT v FROM node")]++
+	}
+	if seen["r1"] != 2 || seen["r2"] != 2 {
+		t.Errorf("Query() => %v, want round robin", seen)
+	}
+
+	if v := MustQuery[string](ForcePrimary(ctx), "SELECT v FROM node"); v != "p" {
+		t.Errorf("Query(ForcePrimary) => %s, want p", v)
+	}
+	if v := MustQuery[string](ctx, "INSERT INTO node (v) VALUES ('x') RETURNING v"); v != "x" {
+		t.Errorf("Query(RETURNING) => %s, want x", v)
+	}
+
+	txCtx := BeginTx(ctx, false)
+	v := MustQuery[string](txCtx, "SELECT v FROM node")
+	CloseTx(txCtx)
+	if v != "p" {
+		t.Errorf("Query(tx) => %s, want p", v)
+	}
+
+	// 检查失败的从库被移出轮换。
+	r1.Close()
+	if n := ds.CheckReplicas(context.TODO()); n != 1 {
+		t.Errorf("CheckReplicas() => %d, want 1", n)
+	}
+	ds.SetReplicaPolicy(ReplicaLeastConn)
+	for i := 0; i < 2; i++ {
+		if v := MustQuery[string](ctx, "SELECT v FROM node"); v != "r2" {
+			t.Errorf("Query() => %s, want r2", v)
+		}
+	}
+	if s := ds.Replicas(); len(s) != 2 || s[0].Healthy || s[0].LastErr == nil || !s[1].Healthy {
+		t.Errorf("Replicas() => %+v", s)
+	}
+}
+
+func TestIsReadOnlySql(t *testing.T) {
+	cases := map[string]bool{
+		"SELECT * FROM user":                                true,
+		" (SELECT 1) UNION (SELECT 2)":                      true,
+		"-- comment\nWITH t AS (SELECT 1) SELECT * FROM t":  true,
+		"SHOW TABLES":                                       true,
+		"SELECT * FROM user WHERE id = 1 FOR UPDATE":        false,
+		"SELECT * FROM user LOCK IN SHARE MODE":             false,
+		"SELECT * INTO backup FROM user":                    false,
+		"WITH t AS (DELETE FROM user RETURNING *) SELECT 1": false,
+		"EXPLAIN ANALYZE UPDATE user SET user_name = 'a'":   false,
+		"INSERT INTO user (user_name) VALUES ('a')":         false,
+		"UPDATE user SET user_name = 'a'":                   false,
+		"SELECT * FROM user FOR SHARE":                      false,
+		"SELECT nextval('user_id_seq')":                     false,
+		"SELECT GET_LOCK('job', 10)":                        false,
+		"SELECT pg_advisory_lock(1)":                        false,
+		"SELECT pg_try_advisory_xact_lock(1)":               false,
+		"SELECT next_value FROM seq":                        true,
+	}
+
+	for q, want := range cases {
+		if got := isReadOnlySql(q); got != want {
+			t.Errorf("isReadOnlySql(%q) => %v, want %v", q, got, want)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TableScope 表示表的作用域，构造器的BuildContext、QueryPage和ExecOptimistic自动为该表添加对应的条件。
+type TableScope struct {
+	TenantColumn  string // 租户列，例如tenant_id，为空表示不区分租户。
+	DeletedColumn string // 软删除的时间列，例如deleted_at，为空表示不使用软删除。
+}
+
+var (
+	// ErrTenantRequired 表示访问区分租户的表时上下文中没有租户。
+	ErrTenantRequired = errors.New("tenant is required")
+	// ErrNotSoftDelete 表示表没有注册软删除的列。
+	ErrNotSoftDelete = errors.New("table does not support soft delete")
+	// ErrScopeNotApplied 表示无法为注册了作用域的表添加作用域的条件，例如使用了不带上下文的Build。
+	ErrScopeNotApplied = errors.New("table scope is not applied")
+)
+
+var (
+	// trailingClausePattern 匹配WHERE之后的子句，没有WHERE子句时作用域的条件插入在这些子句之前。
+	trailingClausePattern = regexp.MustCompile(`(?i)^\s*(GROUP\s+BY|HAVING|WINDOW|ORDER\s+BY|LIMIT|OFFSET|FOR\s+UPDATE|FOR\s+SHARE|RETURNING|UNION|INTERSECT|EXCEPT)\b`)
+	// tableRefPattern 匹配表名及其别名。
+	tableRefPattern = regexp.MustCompile(`^\s+([A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)?)(?:\s+(?:(?i:AS)\s+)?([A-Za-z_][A-Za-z0-9_]*))?`)
+	// aliasKeywords 是可能紧跟在表名之后的关键字，不能作为别名。
+	aliasKeywords = map[string]bool{
+		"WHERE": true, "SET": true, "JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "CROSS": true,
+		"NATURAL": true, "STRAIGHT_JOIN": true, "ON": true, "USING": true, "GROUP": true, "HAVING": true, "WINDOW": true,
+		"ORDER": true, "LIMIT": true, "OFFSET": true, "FOR": true, "UNION": true, "INTERSECT": true, "EXCEPT": true, "RETURNING": true,
+	}
+)
+
+// tenantCtxKey 用于在上下文中记录租户的key。
+type tenantCtxKey struct{}
+
+// deletedCtxKey 用于在上下文中记录是否包含已删除记录的key。
+type deletedCtxKey struct{}
+
+// allTenantsCtxKey 用于在上下文中记录是否访问所有租户的key。
+type allTenantsCtxKey struct{}
+
+var (
+	scopeLock sync.RWMutex
+	scopes    = make(map[string]TableScope)
+)
+
+// RegisterScope 注册表的作用域，替换已有的作用域，scope的各列都为空时注销。
+// table需要与构造器中使用的表名一致，不包括别名。应当在初始化时注册。
+// 注册之后SelectBuilder、UpdateBuilder和DeleteBuilder的Build对该表返回ErrScopeNotApplied，必须使用BuildContext。
+func RegisterScope(table string, scope TableScope) {
+	scopeLock.Lock()
+	defer scopeLock.Unlock()
+
+	if scope == (TableScope{}) {
+		delete(scopes, table)
+	} else {
+		scopes[table] = scope
+	}
+}
+
+// GetScope 获取表的作用域，不存在时返回false。
+func GetScope(table string) (TableScope, bool) {
+	scopeLock.RLock()
+	defer scopeLock.RUnlock()
+
+	scope, ok := scopes[table]
+	return scope, ok
+}
+
+// WithTenant 返回一个新的上下文，之后使用该上下文构造的sql只访问指定租户的记录。
+func WithTenant(ctx context.Context, tenant any) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// TenantOf 获取上下文中的租户，不存在时返回false。
+func TenantOf(ctx context.Context) (any, bool) {
+	tenant := ctx.Value(tenantCtxKey{})
+	return tenant, tenant != nil
+}
+
+// WithAllTenants 返回一个新的上下文，之后使用该上下文构造的sql访问所有租户的记录，用于后台任务等跨租户的场景。
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsCtxKey{}, true)
+}
+
+// WithDeleted 返回一个新的上下文，之后使用该上下文构造的sql也访问已经软删除的记录。
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deletedCtxKey{}, true)
+}
+
+// isDeletedIncluded 判断上下文是否要求包含已删除的记录。
+func isDeletedIncluded(ctx context.Context) bool {
+	deleted, _ := ctx.Value(deletedCtxKey{}).(bool)
+	return deleted
+}
+
+// conditions 获取作用域的条件，使用?作为占位符，column用于生成列名，filterDeleted表示是否排除已删除的记录。
+func (s TableScope) conditions(ctx context.Context, column func(name string) string, filterDeleted bool) ([]fragment, error) {
+	var conds []fragment
+	if all, _ := ctx.Value(allTenantsCtxKey{}).(bool); s.TenantColumn != "" && !all {
+		tenant, ok := TenantOf(ctx)
+		if !ok {
+			return nil, ErrTenantRequired
+		}
+		conds = append(conds, fragment{joint: "AND", sql: column(s.TenantColumn) + " = ?", args: []any{tenant}})
+	}
+	if s.DeletedColumn != "" && filterDeleted && !isDeletedIncluded(ctx) {
+		conds = append(conds, fragment{joint: "AND", sql: column(s.DeletedColumn) + " IS NULL"})
+	}
+	return conds, nil
+}
+
+// scopeConditions 获取表的作用域条件，table可以带有别名，没有注册作用域时返回nil。
+func scopeConditions(ctx context.Context, d DbDialect, table string, qualify, filterDeleted bool) ([]fragment, error) {
+	name, qualifier := splitTable(table)
+	scope, ok := GetScope(name)
+	if !ok {
+		return nil, nil
+	}
+	return scope.conditions(ctx, func(name string) string {
+		if qualify {
+			return d.QuoteIdent(qualifier + "." + name)
+		}
+		return d.QuoteIdent(name)
+	}, filterDeleted)
+}
+
+// checkUnscoped 检查表没有注册作用域，用于不带上下文的Build，避免遗漏作用域的条件。
+func checkUnscoped(table string) error {
+	name, _ := splitTable(table)
+	if _, ok := GetScope(name); ok {
+		return fmt.Errorf("%w: %s has registered scope, use BuildContext", ErrScopeNotApplied, name)
+	}
+	return nil
+}
+
+// splitTable 将"user u"或者"user AS u"拆分为表名和用于限定列名的别名，没有别名时使用表名。
+// 子查询等表达式返回空字符串。
+func splitTable(table string) (string, string) {
+	fields := strings.Fields(table)
+	if len(fields) == 0 || !identPattern.MatchString(fields[0]) {
+		return "", ""
+	} else if len(fields) == 1 {
+		return fields[0], fields[0]
+	} else if len(fields) == 2 {
+		return fields[0], fields[1]
+	} else if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+		return fields[0], fields[2]
+	}
+	return "", ""
+}
+
+// BuildContext 与Build相同，同时为FROM的表添加RegisterScope注册的作用域条件，连接的表需要自行添加条件。
+func (b *SelectBuilder) BuildContext(ctx context.Context) (string, []any, error) {
+	conds, err := scopeConditions(ctx, b.dialect, b.from, len(b.joins) > 0, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scoped := *b
+	scoped.where = andConditions(b.where, conds...)
+	return scoped.build()
+}
+
+// BuildContext 与Build相同，同时添加RegisterScope注册的作用域条件，不更新已经软删除的记录。
+func (b *UpdateBuilder) BuildContext(ctx context.Context) (string, []any, error) {
+	conds, err := scopeConditions(ctx, b.dialect, b.table, false, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scoped := *b
+	scoped.where = andConditions(b.where, conds...)
+	return scoped.build()
+}
+
+// BuildContext 与Build相同，同时添加租户的条件。DELETE语句总是物理删除，包括已经软删除的记录，
+// 需要软删除时使用SoftDelete。
+func (b *DeleteBuilder) BuildContext(ctx context.Context) (string, []any, error) {
+	conds, err := scopeConditions(ctx, b.dialect, b.table, false, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scoped := *b
+	scoped.where = andConditions(b.where, conds...)
+	return scoped.build()
+}
+
+// SoftDelete 将DeleteBuilder构造的删除转换为更新，把软删除的列设置为当前时间，返回受影响的行数。
+// 已经软删除的记录不会被再次更新。表没有注册软删除的列时返回ErrNotSoftDelete。
+func SoftDelete(ctx context.Context, b *DeleteBuilder) (int64, error) {
+	name, _ := splitTable(b.table)
+	scope, ok := GetScope(name)
+	if !ok || scope.DeletedColumn == "" {
+		return 0, fmt.Errorf("%w: %s", ErrNotSoftDelete, b.table)
+	}
+
+	u := &UpdateBuilder{dialect: b.dialect, table: b.table, where: b.where}
+	// 忽略WithDeleted，保留第一次删除的时间。
+	query, args, err := u.Set(scope.DeletedColumn, time.Now()).BuildContext(context.WithValue(ctx, deletedCtxKey{}, false))
+	if err != nil {
+		return 0, err
+	}
+	return Exec[int64](ctx, query, args...)
+}
+
+// BuildContext 生成添加了RegisterScope注册的作用域条件和乐观锁条件的sql，args是调用者的参数，
+// 返回的参数在其后追加了租户和乐观锁的参数，因此需要参数时只能使用位置参数。
+// 作用域的表是SELECT语句最外层FROM之后的第一个表，或者UPDATE、DELETE FROM之后的表，列名使用别名或者表名限定；
+// 其它语句（例如WITH和INSERT）不添加作用域的条件。WHERE子句必须通过Where构造，没有WHERE子句时自动添加。
+// String不添加作用域的条件。
+func (b *SqlBuilder) BuildContext(ctx context.Context, args ...any) (string, []any, error) {
+	sb, args, err := b.scoped(ctx, args)
+	if err != nil {
+		return "", nil, err
+	}
+	query, lockArgs, err := sb.optimisticSql(len(args))
+	if err != nil {
+		return "", nil, err
+	}
+	return query, append(args[:len(args):len(args)], lockArgs...), nil
+}
+
+// scoped 返回添加了作用域条件的副本和追加了作用域参数的args，表没有注册作用域时返回b本身。
+func (b *SqlBuilder) scoped(ctx context.Context, args []any) (*SqlBuilder, []any, error) {
+	at := b.whereAt - 1
+	if at < 0 {
+		at = b.trailingClauseAt()
+	}
+	head := stripComments(strings.Join(b.texts[:at], "\n"))
+	table, qualifier, filterDeleted := mainTable(head)
+	scope, ok := GetScope(table)
+	if !ok {
+		return b, args, nil
+	} else if topLevelKeyword(head, "WHERE") >= 0 {
+		return nil, nil, fmt.Errorf("%w: WHERE clause of %s must be built with Where", ErrScopeNotApplied, table)
+	}
+
+	conds, err := scope.conditions(ctx, func(name string) string { return qualifier + "." + name }, filterDeleted)
+	if err != nil {
+		return nil, nil, err
+	} else if len(conds) == 0 {
+		return b, args, nil
+	}
+
+	texts := make([]string, 0, len(conds))
+	for _, c := range conds {
+		if len(c.args) == 0 {
+			texts = append(texts, c.sql)
+			continue
+		}
+		// 作用域的参数使用位置参数，编号在调用者的参数之后。
+		if phs, err := scanPlaceholders(b.dialect, b.String()); err != nil {
+			return nil, nil, err
+		} else if len(phs) > 0 && phs[0].name != "" {
+			return nil, nil, fmt.Errorf("%w: %s requires positional placeholders", ErrScopeNotApplied, table)
+		}
+		args = append(args[:len(args):len(args)], c.args...)
+		texts = append(texts, strings.Replace(c.sql, "?", ":"+strconv.Itoa(len(args)), 1))
+	}
+
+	sb := *b
+	if b.whereAt > 0 {
+		sb.texts = append([]string(nil), b.texts...)
+		sb.texts[at] = andWhere(sb.texts[at], texts...)
+	} else {
+		sb.texts = append(append(append(make([]string, 0, len(b.texts)+1), b.texts[:at]...), "WHERE\n  "+strings.Join(texts, "\n  AND ")), b.texts[at:]...)
+		for _, p := range []*int{&sb.orderAt, &sb.limitAt, &sb.setAt} {
+			if *p > at {
+				*p++
+			}
+		}
+		sb.whereAt = at + 1
+	}
+	return &sb, args, nil
+}
+
+// trailingClauseAt 获取第一个WHERE之后的子句（GROUP BY、ORDER BY、LIMIT等）在texts中的位置，没有时返回len(texts)。
+func (b *SqlBuilder) trailingClauseAt() int {
+	for i := 1; i < len(b.texts); i++ {
+		if i+1 == b.orderAt || i+1 == b.limitAt || trailingClausePattern.MatchString(b.texts[i]) {
+			return i
+		}
+	}
+	return len(b.texts)
+}
+
+// mainTable 获取sql的作用域的表和用于限定列名的别名，filterDeleted表示是否排除已删除的记录，DELETE语句不排除。
+func mainTable(head string) (string, string, bool) {
+	upper := strings.ToUpper(head)
+	keyword, filterDeleted := strings.TrimLeft(upper, " \t\r\n"), true
+	if i := strings.IndexFunc(keyword, func(r rune) bool { return !isIdentPart(byte(r)) }); i >= 0 {
+		keyword = keyword[:i]
+	}
+
+	pos := -1
+	if keyword == "SELECT" {
+		if pos = topLevelKeyword(upper, "FROM"); pos >= 0 {
+			pos += len("FROM")
+		}
+	} else if keyword == "UPDATE" {
+		pos = strings.Index(upper, "UPDATE") + len("UPDATE")
+	} else if keyword == "DELETE" {
+		if i := topLevelKeyword(upper, "FROM"); i >= 0 && strings.TrimSpace(upper[strings.Index(upper, "DELETE")+len("DELETE"):i]) == "" {
+			pos, filterDeleted = i+len("FROM"), false
+		}
+	}
+	if pos < 0 {
+		return "", "", false
+	}
+
+	m := tableRefPattern.FindStringSubmatch(head[pos:])
+	if m == nil {
+		return "", "", false
+	} else if m[2] == "" || aliasKeywords[strings.ToUpper(m[2])] {
+		return m[1], m[1], filterDeleted
+	}
+	return m[1], m[2], filterDeleted
+}
+
+// topLevelKeyword 查找不在括号和字符串中的关键字，返回其位置，不存在时返回-1。
+func topLevelKeyword(s, keyword string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '\'' || c == '"' || c == '`' {
+			if j := strings.IndexByte(s[i+1:], c); j >= 0 {
+				i += j + 1
+			}
+		} else if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		} else if depth == 0 && (i == 0 || !isIdentPart(s[i-1])) && strings.HasPrefix(strings.ToUpper(s[i:min(i+len(keyword), len(s))]), keyword) &&
+			(i+len(keyword) == len(s) || !isIdentPart(s[i+len(keyword)])) {
+			return i
+		}
+	}
+	return -1
+}
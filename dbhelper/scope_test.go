@@ -0,0 +1,141 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Lord-Haart/go-common/utils"
+)
+
+func TestScopeBuild(t *testing.T) {
+	RegisterScope("doc", TableScope{TenantColumn: "tenant_id", DeletedColumn: "deleted_at"})
+	defer RegisterScope("doc", TableScope{})
+
+	ctx := WithTenant(context.TODO(), 7)
+	q, args, err := NewSelectBuilder("d.id").From("doc d").Join("JOIN user u ON u.id = d.owner_id").
+		Where().And("d.title = ?", "a").Or("d.title = ?", "b").End().
+		BuildContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT \"d\".\"id\"\nFROM doc d\nJOIN user u ON u.id = d.owner_id\nWHERE\n  (d.title = ?\n  OR d.title = ?)\n  AND \"d\".\"tenant_id\" = ?\n  AND \"d\".\"deleted_at\" IS NULL"
+	if q != want || !reflect.DeepEqual(args, []any{"a", "b", 7}) {
+		t.Errorf("BuildContext() => %q %v, want %q", q, args, want)
+	}
+
+	if q, _, err := NewSelectBuilder().From("doc").BuildContext(WithDeleted(ctx)); err != nil {
+		t.Fatal(err)
+	} else if want := "SELECT *\nFROM \"doc\"\nWHERE\n  \"tenant_id\" = ?"; q != want {
+		t.Errorf("BuildContext(WithDeleted) => %q, want %q", q, want)
+	}
+	if _, _, err := NewUpdateBuilder("doc").Set("title", "x").BuildContext(context.TODO()); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("BuildContext(no tenant) => %v, want ErrTenantRequired", err)
+	}
+	if q, _, err := NewSelectBuilder().From("user").BuildContext(context.TODO()); err != nil || q != "SELECT *\nFROM \"user\"" {
+		t.Errorf("BuildContext(no scope) => %q, %v", q, err)
+	}
+}
+
+func TestScopeSqlBuilder(t *testing.T) {
+	RegisterScope("doc", TableScope{TenantColumn: "tenant_id", DeletedColumn: "deleted_at"})
+	defer RegisterScope("doc", TableScope{})
+
+	ctx := WithTenant(context.TODO(), 7)
+	q, args, err := NewSqlBuilder("SELECT d.id FROM doc d").
+		Append("LEFT JOIN user u ON u.id = d.owner_id").
+		Where().Append("d.title = :1 OR d.title = :2").End().
+		OrderBy("d.id").
+		Limit(0, 10).
+		BuildContext(ctx, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT d.id FROM doc d\n  LEFT JOIN user u ON u.id = d.owner_id\nWHERE\n  (d.title = :1 OR d.title = :2)\n  AND d.tenant_id = :3\n  AND d.deleted_at IS NULL\nORDER BY d.id\nLIMIT 10"
+	if q != want || !reflect.DeepEqual(args, []any{"a", "b", 7}) {
+		t.Errorf("BuildContext() => %q %v, want %q", q, args, want)
+	}
+
+	cases := []struct {
+		b    *SqlBuilder
+		ctx  context.Context
+		args []any
+		want string
+	}{
+		{NewSqlBuilder("SELECT (SELECT COUNT(*) FROM user) AS c, id FROM doc").OrderBy("id"), ctx, nil,
+			"SELECT (SELECT COUNT(*) FROM user) AS c, id FROM doc\nWHERE\n  doc.tenant_id = :1\n  AND doc.deleted_at IS NULL\nORDER BY id"},
+		{NewSqlBuilder("UPDATE doc").Set().Append("title = :1").End(), WithDeleted(ctx), []any{"t"},
+			"UPDATE doc\nSET\n  title = :1\nWHERE\n  doc.tenant_id = :2"},
+		{NewSqlBuilder("DELETE FROM doc AS x"), WithAllTenants(ctx), nil, "DELETE FROM doc AS x"},
+		{NewSqlBuilder("SELECT * FROM user"), context.TODO(), nil, "SELECT * FROM user"},
+	}
+	for _, c := range cases {
+		if q, _, err := c.b.BuildContext(c.ctx, c.args...); err != nil || q != c.want {
+			t.Errorf("BuildContext(%s) => %q, %v, want %q", c.b, q, err, c.want)
+		}
+	}
+
+	for _, b := range []*SqlBuilder{NewSqlBuilder("SELECT * FROM doc WHERE id = :1"), NewSqlBuilder("SELECT * FROM doc").Where().Append("id = :id").End()} {
+		if _, _, err := b.BuildContext(ctx, map[string]any{"id": 1}); !errors.Is(err, ErrScopeNotApplied) {
+			t.Errorf("BuildContext(%s) => %v, want ErrScopeNotApplied", b, err)
+		}
+	}
+	if _, _, err := NewSqlBuilder("SELECT * FROM doc").BuildContext(context.TODO()); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("BuildContext(no tenant) => %v, want ErrTenantRequired", err)
+	}
+	if _, _, err := NewSelectBuilder().From("doc").Build(); !errors.Is(err, ErrScopeNotApplied) {
+		t.Errorf("Build(scoped) => %v, want ErrScopeNotApplied", err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	ctx := context.TODO()
+	MustExec[int](ctx, "CREATE TABLE doc (id INTEGER PRIMARY KEY, tenant_id INTEGER NOT NULL, title VARCHAR(50), deleted_at DATETIME)")
+	defer MustExec[int](ctx, "DROP TABLE doc")
+	MustExec[int](ctx, "INSERT INTO doc (id, tenant_id, title) VALUES (1, 1, 'a'), (2, 1, 'b'), (3, 2, 'c')")
+
+	if _, err := SoftDelete(ctx, NewDeleteBuilder("doc")); !errors.Is(err, ErrNotSoftDelete) {
+		t.Errorf("SoftDelete(no scope) => %v, want ErrNotSoftDelete", err)
+	}
+	RegisterScope("doc", TableScope{TenantColumn: "tenant_id", DeletedColumn: "deleted_at"})
+	defer RegisterScope("doc", TableScope{})
+
+	ctx = WithTenant(ctx, 1)
+	count := func(ctx context.Context) int {
+		q, args, err := NewSelectBuilder("COUNT(*)").From("doc").BuildContext(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return MustQuery[int](ctx, q, args...)
+	}
+
+	if n, err := SoftDelete(ctx, NewDeleteBuilder("doc").Where().And("title = ?", "a").Or("title = ?", "c").End()); err != nil || n != 1 {
+		t.Errorf("SoftDelete() => %d, %v, want 1", n, err)
+	}
+	if n := count(ctx); n != 1 {
+		t.Errorf("count => %d, want 1", n)
+	}
+	if n := count(WithDeleted(ctx)); n != 2 {
+		t.Errorf("count(WithDeleted) => %d, want 2", n)
+	}
+	b := NewSqlBuilder("SELECT id, title FROM doc").Where().Append("title <> :1").End().OrderBy("id")
+	if p, err := QueryPage[Tuple2[int, string]](ctx, b, tuple2Mapper[int, string]{}, &utils.PageRequest{PageSize: 1}, "x"); err != nil {
+		t.Fatal(err)
+	} else if p.TotalElements != 1 || len(p.Content) != 1 || p.Content[0].V1 != 2 {
+		t.Errorf("QueryPage() => %+v", p)
+	}
+	if n, err := ExecUpdate(ctx, NewUpdateBuilder("doc").Set("title", "x")); err != nil || n != 1 {
+		t.Errorf("ExecUpdate() => %d, %v, want 1", n, err)
+	}
+
+	q, args, err := NewDeleteBuilder("doc").BuildContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if n := MustExec[int](ctx, q, args...); n != 2 {
+		t.Errorf("Exec(%s) => %d, want 2", q, n)
+	}
+	if n := count(WithTenant(ctx, 2)); n != 1 {
+		t.Errorf("count(tenant 2) => %d, want 1", n)
+	}
+}
@@ -7,8 +7,11 @@ import (
 )
 
 func DateAdd(expr string, seconds int) string {
-	if dialect == DialectPostgres {
+	switch GetDialect() {
+	case DialectPostgres:
 		return fmt.Sprintf("(%s + INTERVAL '%d seconds')", expr, seconds)
+	case DialectSQLite:
+		return fmt.Sprintf("datetime(%s, '%+d seconds')", expr, seconds)
 	}
 	return fmt.Sprintf("DATE_ADD(%s, INTERVAL %d SECOND)", expr, seconds)
 }
@@ -16,23 +19,68 @@ func DateAdd(expr string, seconds int) string {
 // JsonArrayOverlap 生成 JSON 数组交集判断 SQL 片段。
 // MySQL:      JSON_OVERLAPS(column, param)
 // PostgreSQL: column::jsonb ?| param
+// SQLite:     EXISTS (SELECT 1 FROM json_each(column) a, json_each(param) b WHERE a.value = b.value)
 func JsonArrayOverlap(column, param string) string {
-	if dialect == DialectPostgres {
+	switch GetDialect() {
+	case DialectPostgres:
 		return fmt.Sprintf("%s::jsonb ?| %s", column, param)
+	case DialectSQLite:
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) a, json_each(%s) b WHERE a.value = b.value)", column, param)
 	}
 	return fmt.Sprintf("JSON_OVERLAPS(%s, %s)", column, param)
 }
 
 func DateSub(expr string, seconds int) string {
-	if dialect == DialectPostgres {
+	switch GetDialect() {
+	case DialectPostgres:
 		return fmt.Sprintf("(%s - INTERVAL '%d seconds')", expr, seconds)
+	case DialectSQLite:
+		return fmt.Sprintf("datetime(%s, '%+d seconds')", expr, -seconds)
 	}
 	return fmt.Sprintf("DATE_SUB(%s, INTERVAL %d SECOND)", expr, seconds)
 }
 
+// QuoteIdent 按照方言为标识符加上引号，带有"."的标识符的每个部分分别加引号。
+// MySQL使用反引号，PostgreSQL和SQLite使用双引号。
+func (d DbDialect) QuoteIdent(name string) string {
+	q := `"`
+	if d == DialectMySQL {
+		q = "`"
+	}
+
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = q + strings.ReplaceAll(part, q, q+q) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+// emptySubquery 返回不包含任何记录的子查询，用于展开空切片。
+func (d DbDialect) emptySubquery() string {
+	if d == DialectMySQL {
+		return "SELECT NULL FROM DUAL WHERE 1=0"
+	}
+	return "SELECT NULL WHERE 1=0"
+}
+
+// maxPlaceholders 获取单条sql允许的最大参数个数。
+func (d DbDialect) maxPlaceholders() int {
+	if d == DialectSQLite {
+		return 32766
+	}
+	return 65535
+}
+
 type (
 	SqlBuilder struct {
-		texts []string
+		texts   []string
+		dialect DbDialect
+		orderAt int             // ORDER BY子句在texts中的位置加1，0表示没有。
+		limitAt int             // LIMIT子句在texts中的位置加1，0表示没有。
+		setAt   int             // SET子句在texts中的位置加1，0表示没有。
+		whereAt int             // WHERE子句在texts中的位置加1，0表示没有。
+		seek    *seek           // 键集分页的条件。
+		lock    *optimisticLock // 乐观锁的条件。
 	}
 
 	DynamicSqlBuilder struct {
@@ -40,24 +88,46 @@ type (
 		prefix  string
 		suffix  string
 		joint   string
+		at      *int // 用于记录子句在builder.texts中的位置。
 		builder *SqlBuilder
 	}
 
 	InsertSqlBuilder struct {
-		quote   string
-		pos     int
-		cols    []string
-		params  []int
-		builder *SqlBuilder
+		quote        string
+		pos          int
+		cols         []string
+		params       []int
+		conflict     conflictAction
+		conflictKeys []string
+		updateCols   []string
+		returning    []string
+		builder      *SqlBuilder
 	}
 )
 
+// conflictAction 表示插入记录发生唯一键冲突时的处理方式。
+type conflictAction int
+
+const (
+	conflictNone conflictAction = iota
+	conflictDoNothing
+	conflictDoUpdate
+)
+
+// NewSqlBuilder 创建sql构造器，方言默认为默认数据源的方言。
 func NewSqlBuilder(sql string) *SqlBuilder {
 	return &SqlBuilder{
-		texts: []string{sql},
+		texts:   []string{sql},
+		dialect: GetDialect(),
 	}
 }
 
+// WithDialect 设置构造sql时使用的方言。
+func (b *SqlBuilder) WithDialect(d DbDialect) *SqlBuilder {
+	b.dialect = d
+	return b
+}
+
 func (b *SqlBuilder) String() string {
 	return strings.Join(b.texts, "\n")
 }
@@ -93,16 +163,21 @@ func (b *SqlBuilder) Inserter(quote string) *InsertSqlBuilder {
 }
 
 func (b *SqlBuilder) Where() *DynamicSqlBuilder {
-	return b.Dynamic("WHERE\n ", "", "\n  AND ")
+	d := b.Dynamic("WHERE\n ", "", "\n  AND ")
+	d.at = &b.whereAt
+	return d
 }
 
 func (b *SqlBuilder) Set() *DynamicSqlBuilder {
-	return b.Dynamic("SET\n ", "", ",\n  ")
+	d := b.Dynamic("SET\n ", "", ",\n  ")
+	d.at = &b.setAt
+	return d
 }
 
 func (b *SqlBuilder) OrderBy(sql ...string) *SqlBuilder {
 	if len(sql) > 0 {
 		b.append0("ORDER BY " + strings.Join(sql, ", "))
+		b.orderAt = len(b.texts)
 	}
 	return b
 }
@@ -120,9 +195,45 @@ func (b *SqlBuilder) Limit(startRowIndex, maximumRows int) *SqlBuilder {
 		sql = "LIMIT " + strconv.FormatInt(int64(maximumRows), 10) + " OFFSET " + strconv.FormatInt(int64(startRowIndex), 10)
 	}
 	b.append0(sql)
+	b.limitAt = len(b.texts)
 	return b
 }
 
+// Seek 使用键集分页代替OFFSET，只用于QueryPage。
+// column是结果集中唯一且非空的列，例如主键；after是上一页最后一条记录的该列的值，nil表示第一页。
+// 查询结果按照该列排序，desc为true时降序，并忽略OrderBy指定的排序。
+func (b *SqlBuilder) Seek(column string, after any, desc bool) *SqlBuilder {
+	b.seek = &seek{column: column, after: after, desc: desc}
+	return b
+}
+
+// andWhere 在WHERE子句的条件之后追加使用AND连接的条件，原有的条件包含OR时作为一个整体参与运算。
+func andWhere(where string, conds ...string) string {
+	if body := strings.TrimPrefix(where, "WHERE\n  "); orPattern.MatchString(body) {
+		where = "WHERE\n  (" + body + ")"
+	}
+	return where + "\n  AND " + strings.Join(conds, "\n  AND ")
+}
+
+// baseSql 获取不包含ORDER BY和LIMIT子句的sql。
+func (b *SqlBuilder) baseSql() string {
+	texts := make([]string, 0, len(b.texts))
+	for i, text := range b.texts {
+		if i+1 != b.orderAt && i+1 != b.limitAt {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// orderSql 获取ORDER BY子句，没有时返回空字符串。
+func (b *SqlBuilder) orderSql() string {
+	if b.orderAt == 0 {
+		return ""
+	}
+	return b.texts[b.orderAt-1]
+}
+
 func (d *DynamicSqlBuilder) Append(sql string) *DynamicSqlBuilder {
 	d.texts = append(d.texts, sql)
 	return d
@@ -146,6 +257,9 @@ func (d *DynamicSqlBuilder) End() *SqlBuilder {
 			ds = " " + ds
 		}
 		d.builder.append0(dp + strings.Join(d.texts, d.joint) + ds)
+		if d.at != nil {
+			*d.at = len(d.builder.texts)
+		}
 	}
 	return d.builder
 }
@@ -181,6 +295,110 @@ func (d *InsertSqlBuilder) End() *SqlBuilder {
 			posList = append(posList, ":"+strconv.Itoa(pos))
 		}
 		d.builder.append0("VALUES (" + strings.Join(posList, ",") + ")")
+		d.appendConflict()
+		d.appendReturning()
 	}
 	return d.builder
 }
+
+// OnConflict 指定发生冲突时用于判断冲突的唯一键列，之后应当调用DoNothing或者DoUpdate指定处理方式。
+// MySQL总是根据所有的唯一键判断冲突，所以会忽略keys。
+func (d *InsertSqlBuilder) OnConflict(keys ...string) *InsertSqlBuilder {
+	d.conflictKeys = keys
+	return d
+}
+
+// DoNothing 发生冲突时忽略待插入的记录。
+// MySQL: ON DUPLICATE KEY UPDATE key=key
+// PostgreSQL/SQLite: ON CONFLICT (keys) DO NOTHING
+func (d *InsertSqlBuilder) DoNothing() *InsertSqlBuilder {
+	d.conflict = conflictDoNothing
+	return d
+}
+
+// DoUpdate 发生冲突时使用待插入的值更新已有记录的指定列，未指定列时更新除唯一键之外的所有待插入的列。
+// MySQL: ON DUPLICATE KEY UPDATE col=VALUES(col)
+// PostgreSQL/SQLite: ON CONFLICT (keys) DO UPDATE SET col=EXCLUDED.col
+func (d *InsertSqlBuilder) DoUpdate(cols ...string) *InsertSqlBuilder {
+	d.conflict = conflictDoUpdate
+	d.updateCols = cols
+	return d
+}
+
+// Returning 指定插入后返回的列，仅在支持RETURNING的PostgreSQL和SQLite上生效，不调用时不添加RETURNING子句。
+// 在MySQL上应当使用ExecLastInsertId获取自增主键。
+func (d *InsertSqlBuilder) Returning(cols ...string) *InsertSqlBuilder {
+	d.returning = cols
+	return d
+}
+
+func (d *InsertSqlBuilder) quoted(col string) string {
+	return d.quote + col + d.quote
+}
+
+func (d *InsertSqlBuilder) appendConflict() {
+	if d.conflict == conflictNone {
+		return
+	}
+
+	updateCols := d.updateCols
+	if d.conflict == conflictDoUpdate && len(updateCols) == 0 {
+		keys := make(map[string]bool, len(d.conflictKeys))
+		for _, key := range d.conflictKeys {
+			keys[key] = true
+		}
+		for _, col := range d.cols {
+			if !keys[col] {
+				updateCols = append(updateCols, col)
+			}
+		}
+	}
+
+	if d.builder.dialect == DialectMySQL {
+		buf := make([]string, 0, len(updateCols))
+		if d.conflict == conflictDoNothing || len(updateCols) == 0 {
+			// 将某一列更新为自身，效果等同于忽略冲突，但是不会像INSERT IGNORE那样忽略其它错误。
+			col := d.cols[0]
+			if len(d.conflictKeys) > 0 {
+				col = d.conflictKeys[0]
+			}
+			buf = append(buf, d.quoted(col)+"="+d.quoted(col))
+		} else {
+			for _, col := range updateCols {
+				buf = append(buf, d.quoted(col)+"=VALUES("+d.quoted(col)+")")
+			}
+		}
+		d.builder.append0("ON DUPLICATE KEY UPDATE " + strings.Join(buf, ","))
+		return
+	}
+
+	target := ""
+	if len(d.conflictKeys) > 0 {
+		buf := make([]string, 0, len(d.conflictKeys))
+		for _, key := range d.conflictKeys {
+			buf = append(buf, d.quoted(key))
+		}
+		target = " (" + strings.Join(buf, ",") + ")"
+	}
+	if d.conflict == conflictDoNothing || len(updateCols) == 0 {
+		d.builder.append0("ON CONFLICT" + target + " DO NOTHING")
+	} else {
+		buf := make([]string, 0, len(updateCols))
+		for _, col := range updateCols {
+			buf = append(buf, d.quoted(col)+"=EXCLUDED."+d.quoted(col))
+		}
+		d.builder.append0("ON CONFLICT" + target + " DO UPDATE SET " + strings.Join(buf, ","))
+	}
+}
+
+func (d *InsertSqlBuilder) appendReturning() {
+	if len(d.returning) == 0 || d.builder.dialect == DialectMySQL {
+		return
+	}
+
+	buf := make([]string, 0, len(d.returning))
+	for _, col := range d.returning {
+		buf = append(buf, d.quoted(col))
+	}
+	d.builder.append0("RETURNING " + strings.Join(buf, ","))
+}
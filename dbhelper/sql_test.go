@@ -1,6 +1,10 @@
 package dbhelper
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"testing"
+)
 
 func TestSqlBuilder1(t *testing.T) {
 	b0 := NewSqlBuilder("SELECT *").
@@ -43,3 +47,186 @@ func TestSqlBuilder3(t *testing.T) {
 
 	t.Logf("sql: %s", b0)
 }
+
+func TestSqliteDialect(t *testing.T) {
+	if r0, err := Query[string](context.TODO(), "SELECT "+DateAdd("'2024-01-01 00:00:00'", 90)); err != nil {
+		t.Fatal(err)
+	} else if r0 != "2024-01-01 00:01:30" {
+		t.Errorf("DateAdd => %v, want 2024-01-01 00:01:30", r0)
+	}
+
+	if r0, err := Query[string](context.TODO(), "SELECT "+DateSub("'2024-01-01 00:00:00'", 60)); err != nil {
+		t.Fatal(err)
+	} else if r0 != "2023-12-31 23:59:00" {
+		t.Errorf("DateSub => %v, want 2023-12-31 23:59:00", r0)
+	}
+
+	if r0, err := Query[bool](context.TODO(), "SELECT "+JsonArrayOverlap("'[1,2,3]'", ":1"), "[3,4]"); err != nil {
+		t.Fatal(err)
+	} else if !r0 {
+		t.Errorf("JsonArrayOverlap => %v, want true", r0)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	if s := DialectMySQL.QuoteIdent("db.user"); s != "`db`.`user`" {
+		t.Errorf("QuoteIdent(mysql) => %v", s)
+	}
+	if s := DialectPostgres.QuoteIdent(`a"b`); s != `"a""b"` {
+		t.Errorf("QuoteIdent(postgres) => %v", s)
+	}
+}
+
+func TestUpsertBuilder(t *testing.T) {
+	b0 := NewSqlBuilder("INSERT INTO foo").WithDialect(DialectMySQL).
+		Inserter("`").
+		Append("id").
+		Append("name").
+		Append("age").
+		OnConflict("id").DoUpdate().
+		End()
+	if s := b0.String(); s != "INSERT INTO foo\n(`id`,`name`,`age`)\nVALUES (:1,:2,:3)\nON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`age`=VALUES(`age`)" {
+		t.Errorf("upsert(mysql) => %s", s)
+	}
+
+	b1 := NewSqlBuilder("INSERT INTO foo").WithDialect(DialectPostgres).
+		Inserter(`"`).
+		Append("id").
+		Append("name").
+		OnConflict("id").DoUpdate("name").
+		Returning("id").
+		End()
+	if s := b1.String(); s != "INSERT INTO foo\n(\"id\",\"name\")\nVALUES (:1,:2)\nON CONFLICT (\"id\") DO UPDATE SET \"name\"=EXCLUDED.\"name\"\nRETURNING \"id\"" {
+		t.Errorf("upsert(postgres) => %s", s)
+	}
+
+	b2 := NewSqlBuilder("INSERT INTO foo").WithDialect(DialectMySQL).
+		Inserter("").
+		Append("id").
+		OnConflict().DoNothing().
+		Returning("id").
+		End()
+	if s := b2.String(); s != "INSERT INTO foo\n(id)\nVALUES (:1)\nON DUPLICATE KEY UPDATE id=id" {
+		t.Errorf("ignore(mysql) => %s", s)
+	}
+
+	b3 := NewSqlBuilder("INSERT INTO foo").WithDialect(DialectSQLite).
+		Inserter(`"`).
+		Append("name").
+		OnConflict("name").DoNothing().
+		End()
+	if s := b3.String(); s != "INSERT INTO foo\n(\"name\")\nVALUES (:1)\nON CONFLICT (\"name\") DO NOTHING" {
+		t.Errorf("ignore(sqlite) => %s", s)
+	}
+	b4 := NewSqlBuilder("INSERT INTO foo").WithDialect(DialectPostgres).Inserter("").Append("name").End()
+	if s := b4.String(); s != "INSERT INTO foo\n(name)\nVALUES (:1)" {
+		t.Errorf("insert(no returning) => %s", s)
+	}
+}
+
+func TestUpsertSqlite(t *testing.T) {
+	ctx := context.TODO()
+
+	query := NewSqlBuilder("INSERT INTO user").
+		Inserter("").
+		Append("user_name").
+		Append("nick_name").
+		OnConflict("user_name").DoUpdate().
+		Returning("id").
+		End().
+		String()
+
+	id1, err := ExecLastInsertId[int64](ctx, query, "upsert1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := ExecLastInsertId[int64](ctx, query, "upsert1", "b")
+	if err != nil {
+		t.Fatal(err)
+	} else if id1 != id2 {
+		t.Errorf("ExecLastInsertId(upsert) => %v, want %v", id2, id1)
+	}
+	if nick, err := Query[string](ctx, "SELECT nick_name FROM user WHERE id = :1", id1); err != nil {
+		t.Fatal(err)
+	} else if nick != "b" {
+		t.Errorf("nick_name => %v, want b", nick)
+	}
+}
+
+func TestSelectBuilder(t *testing.T) {
+	name, status := "adm", 0
+	q, args, err := NewSelectBuilder("id", "user_name", "COUNT(*) AS c").
+		WithDialect(DialectPostgres).
+		From("user").
+		Join("LEFT JOIN user_role r ON r.user_id = user.id AND r.role_name <> ?", "guest").
+		Where().
+		AndIf(name != "", "user_name LIKE ?", name+"%").
+		AndIf(status != 0, "status = ?", status).
+		And("id IN (?) OR id > ?", []int{1, 2}, 100).
+		And("remark <> '?'").
+		End().
+		GroupBy("id", "user_name").
+		OrderBy("id DESC").
+		Limit(20, 10).
+		Build()
+
+	want := `SELECT "id", "user_name", COUNT(*) AS c
+FROM "user"
+LEFT JOIN user_role r ON r.user_id = user.id AND r.role_name <> $1
+WHERE
+  user_name LIKE $2
+  AND (id IN ($3,$4) OR id > $5)
+  AND remark <> '?'
+GROUP BY "id", "user_name"
+ORDER BY id DESC
+LIMIT 10 OFFSET 20`
+	if err != nil {
+		t.Fatal(err)
+	} else if q != want || len(args) != 5 || args[1] != "adm%" || args[4] != 100 {
+		t.Errorf("Build() => %s %v", q, args)
+	}
+
+	if _, _, err := NewSelectBuilder().From("user").Where().And("id = ? AND name = ?", 1).End().Build(); err == nil {
+		t.Errorf("Build(missing arg) => nil, want error")
+	}
+}
+
+func TestUpdateDeleteBuilder(t *testing.T) {
+	ctx := context.TODO()
+	MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "builder1")
+
+	q, args, err := NewUpdateBuilder("user").
+		Set("nick_name", "Builder").
+		SetIf(false, "password", "x").
+		Where().And("user_name = ?", "builder1").End().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	} else if n := MustExec[int](ctx, q, args...); n != 1 {
+		t.Errorf("Exec(%s) => %d, want 1", q, n)
+	}
+
+	// 在Build时按照最终的方言为列名加上引号。
+	if q, _, err := NewUpdateBuilder("user").Set("nick_name", "x").WithDialect(DialectMySQL).Where().And("id = ?", 1).End().Build(); err != nil {
+		t.Fatal(err)
+	} else if q != "UPDATE `user`\nSET\n  `nick_name` = ?\nWHERE\n  id = ?" {
+		t.Errorf("Build(WithDialect) => %q", q)
+	}
+	if _, _, err := NewUpdateBuilder("user").Where().And("id = ?", 1).End().Build(); !errors.Is(err, ErrNoSetClause) {
+		t.Errorf("Build(no SET) => %v, want ErrNoSetClause", err)
+	}
+
+	q, args, err = NewSelectBuilder("nick_name").From("user").Where().And("user_name = ?", "builder1").End().Build()
+	if err != nil {
+		t.Fatal(err)
+	} else if s := MustQuery[string](ctx, q, args...); s != "Builder" {
+		t.Errorf("Query(%s) => %q, want Builder", q, s)
+	}
+
+	q, args, err = NewDeleteBuilder("user").Where().And("user_name IN (?)", []string{"builder1"}).End().Build()
+	if err != nil {
+		t.Fatal(err)
+	} else if n := MustExec[int](ctx, q, args...); n != 1 {
+		t.Errorf("Exec(%s) => %d, want 1", q, n)
+	}
+}
@@ -0,0 +1,189 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// HealthCheckTimeout 健康检查的默认超时时间，上下文的截止时间更早时以上下文为准。
+var HealthCheckTimeout = 3 * time.Second
+
+// PoolStats 表示连接池的统计信息，参见sql.DBStats。
+type PoolStats struct {
+	MaxOpenConnections int   `json:"maxOpenConnections"` // 最大连接数，0表示不限制。
+	OpenConnections    int   `json:"openConnections"`    // 已经建立的连接数。
+	InUse              int   `json:"inUse"`              // 正在使用的连接数。
+	Idle               int   `json:"idle"`               // 空闲的连接数。
+	WaitCount          int64 `json:"waitCount"`          // 等待连接的总次数。
+	WaitDurationMs     int64 `json:"waitDurationMs"`     // 等待连接的总时间，单位是毫秒。
+	MaxIdleClosed      int64 `json:"maxIdleClosed"`      // 因为超出最大空闲连接数而关闭的连接数。
+	MaxIdleTimeClosed  int64 `json:"maxIdleTimeClosed"`  // 因为超出最大空闲时间而关闭的连接数。
+	MaxLifetimeClosed  int64 `json:"maxLifetimeClosed"`  // 因为超出最大存活时间而关闭的连接数。
+}
+
+// ReplicaStats 表示从库的状态和连接池的统计信息。
+type ReplicaStats struct {
+	Name      string     `json:"name"`
+	Healthy   bool       `json:"healthy"`
+	Error     string     `json:"error,omitempty"`     // 最近一次检查时发生的错误。
+	CheckedAt *time.Time `json:"checkedAt,omitempty"` // 最近一次检查的时间，从未检查时为nil。
+	Pool      PoolStats  `json:"pool"`
+}
+
+// DataSourceStats 表示数据源的统计信息。
+type DataSourceStats struct {
+	Name      string         `json:"name"`
+	Dialect   DbDialect      `json:"dialect"`
+	Pool      PoolStats      `json:"pool"` // 主库的连接池。
+	StmtCache StmtCacheStats `json:"stmtCache"`
+	Replicas  []ReplicaStats `json:"replicas,omitempty"`
+}
+
+// HealthStatus 表示数据源的健康检查结果。
+type HealthStatus struct {
+	Name     string         `json:"name"`
+	Healthy  bool           `json:"healthy"`
+	Error    string         `json:"error,omitempty"`
+	Duration int64          `json:"durationMs"` // 检查耗时，单位是毫秒。
+	Replicas []ReplicaStats `json:"replicas,omitempty"`
+}
+
+func newPoolStats(s sql.DBStats) PoolStats {
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDurationMs:     s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
+
+// Stats 获取数据源的统计信息。
+func (ds *DataSource) Stats() DataSourceStats {
+	result := DataSourceStats{
+		Name:      ds.name,
+		Dialect:   ds.dialect,
+		Pool:      newPoolStats(ds.db.Stats()),
+		StmtCache: ds.stmts.snapshot(),
+	}
+	for _, r := range ds.replicaList() {
+		result.Replicas = append(result.Replicas, r.stats())
+	}
+	return result
+}
+
+// stats 获取从库的状态和连接池的统计信息。
+func (r *replica) stats() ReplicaStats {
+	status := r.status.Load()
+	result := ReplicaStats{Name: r.name, Healthy: status.Healthy, Pool: newPoolStats(r.db.Stats())}
+	if status.LastErr != nil {
+		result.Error = status.LastErr.Error()
+	}
+	if !status.CheckedAt.IsZero() {
+		result.CheckedAt = &status.CheckedAt
+	}
+	return result
+}
+
+// Stats 获取所有数据源的统计信息，按照名称排序。
+func Stats() []DataSourceStats {
+	list := allDataSources()
+	result := make([]DataSourceStats, 0, len(list))
+	for _, ds := range list {
+		result = append(result, ds.Stats())
+	}
+	return result
+}
+
+// HealthCheck 在数据源的主库上执行一条简单的查询以检查数据库是否可用，超时时间为HealthCheckTimeout。
+// 同时检查所有从库，不可用的从库被移出轮换，但是不影响返回值，因为此时查询会在主库上执行。
+func (ds *DataSource) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	var n int
+	if err := ds.db.QueryRowContext(ctx, "SELECT 1").Scan(&n); err != nil {
+		return ClassifyError(err)
+	}
+	ds.CheckReplicas(ctx)
+	return nil
+}
+
+// HealthCheck 检查上下文对应的数据源是否可用，参见DataSource.HealthCheck。
+func HealthCheck(ctx context.Context) error {
+	ds, _, err := resolveDataSource(ctx)
+	if err != nil {
+		return err
+	}
+	return ds.HealthCheck(ctx)
+}
+
+// checkHealth 检查所有数据源，按照名称排序。
+func checkHealth(ctx context.Context) []HealthStatus {
+	list := allDataSources()
+	result := make([]HealthStatus, 0, len(list))
+	for _, ds := range list {
+		start := time.Now()
+		status := HealthStatus{Name: ds.name, Healthy: true}
+		if err := ds.HealthCheck(ctx); err != nil {
+			status.Healthy, status.Error = false, err.Error()
+		}
+		status.Duration = time.Since(start).Milliseconds()
+		for _, r := range ds.replicaList() {
+			status.Replicas = append(status.Replicas, r.stats())
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// allDataSources 获取所有数据源，按照名称排序。
+func allDataSources() []*DataSource {
+	dsLock.RLock()
+	list := make([]*DataSource, 0, len(dataSources))
+	for _, ds := range dataSources {
+		list = append(list, ds)
+	}
+	dsLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// StatsHandler 返回以JSON格式输出所有数据源统计信息的http.Handler。
+func StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, Stats())
+	})
+}
+
+// HealthHandler 返回检查所有数据源并以JSON格式输出结果的http.Handler。
+// 所有数据源都可用时状态码为200，否则为503。
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := checkHealth(r.Context())
+		code, status := http.StatusOK, "UP"
+		for _, s := range list {
+			if !s.Healthy {
+				code, status = http.StatusServiceUnavailable, "DOWN"
+				break
+			}
+		}
+		writeJson(w, code, map[string]any{"status": status, "dataSources": list})
+	})
+}
+
+func writeJson(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
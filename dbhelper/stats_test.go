@@ -0,0 +1,75 @@
+package dbhelper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.TODO()
+	MustQuery[int](ctx, "SELECT 1")
+
+	list := Stats()
+	var ds *DataSourceStats
+	for i := range list {
+		if list[i].Name == DefaultDataSource {
+			ds = &list[i]
+		}
+	}
+	if ds == nil || ds.Dialect != DialectSQLite || ds.Pool.MaxOpenConnections != 1 || ds.Pool.OpenConnections != 1 {
+		t.Errorf("Stats() => %+v", list)
+	}
+
+	if err := HealthCheck(ctx); err != nil {
+		t.Errorf("HealthCheck() => %v", err)
+	}
+	if err := HealthCheck(WithDataSource(ctx, "missing")); err == nil {
+		t.Errorf("HealthCheck(missing) => nil, want error")
+	}
+
+	w := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var body struct {
+		Status      string         `json:"status"`
+		DataSources []HealthStatus `json:"dataSources"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	} else if w.Code != http.StatusOK || body.Status != "UP" || len(body.DataSources) == 0 {
+		t.Errorf("HealthHandler() => %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	ctx := context.TODO()
+	MustQuery[int](ctx, "SELECT 1")
+	if _, err := Query[int](ctx, "SELECT * FROM missing_table"); err == nil {
+		t.Fatal("Query(missing_table) => nil, want error")
+	}
+
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, s := range []string{
+		"# TYPE dbhelper_pool_open_connections gauge\n",
+		`dbhelper_pool_max_open_connections{datasource="default",pool="primary"} 1` + "\n",
+		"# TYPE dbhelper_query_duration_seconds histogram\n",
+		`dbhelper_query_duration_seconds_bucket{datasource="default",le="+Inf"} `,
+		`dbhelper_query_duration_seconds_bucket{datasource="default",le="0.001"} `,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("WriteMetrics() => missing %q in\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, `dbhelper_query_errors_total{datasource="default"} 0`) {
+		t.Errorf("WriteMetrics() => no errors counted")
+	}
+
+	if v := quoteLabel("a\"b\\c\n"); v != `"a\"b\\c\n"` {
+		t.Errorf("quoteLabel() => %s", v)
+	}
+}
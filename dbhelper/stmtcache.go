@@ -0,0 +1,149 @@
+package dbhelper
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// DefaultStmtCacheSize 数据源默认缓存的预编译语句的个数。
+const DefaultStmtCacheSize = 256
+
+// StmtCacheStats 表示预编译语句缓存的统计信息。
+type StmtCacheStats struct {
+	Size      int   `json:"size"`      // 当前缓存的语句个数。
+	Capacity  int   `json:"capacity"`  // 最多缓存的语句个数，0表示不缓存。
+	Hits      int64 `json:"hits"`      // 命中次数。
+	Misses    int64 `json:"misses"`    // 未命中次数。
+	Evictions int64 `json:"evictions"` // 因为超出容量而被淘汰的语句个数。
+}
+
+// stmtCache 按照改写后的sql缓存预编译语句，超出容量时淘汰最近最少使用的语句。
+// 被淘汰的语句在所有使用者释放之后才会关闭。
+type stmtCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // 元素为*cachedStmt，最近使用的在前。
+	items    map[string]*list.Element
+	stats    StmtCacheStats
+}
+
+// cachedStmt 表示缓存中的一条语句。
+type cachedStmt struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int
+	evicted bool
+}
+
+func newStmtCache(capacity int) *stmtCache {
+	return &stmtCache{capacity: capacity, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// lookup 查找缓存的语句并增加引用计数，不存在时返回nil。
+func (c *stmtCache) lookup(query string) *cachedStmt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[query]; ok {
+		c.ll.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.refs++
+		c.stats.Hits++
+		return cs
+	}
+	c.stats.Misses++
+	return nil
+}
+
+// get 获取缓存的语句，不存在时在连接池上准备并加入缓存。返回的语句使用完毕后必须调用release。
+func (c *stmtCache) get(ctx context.Context, db *sql.DB, query string) (*cachedStmt, error) {
+	if cs := c.lookup(query); cs != nil {
+		return cs, nil
+	}
+
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[query]; ok {
+		// 其它goroutine已经准备了相同的语句。
+		stmt.Close()
+		c.ll.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.refs++
+		return cs, nil
+	}
+
+	cs := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	if c.capacity <= 0 {
+		cs.evicted = true
+		return cs, nil
+	}
+	c.items[query] = c.ll.PushFront(cs)
+	for c.ll.Len() > c.capacity {
+		c.evict(c.ll.Back())
+		c.stats.Evictions++
+	}
+	return cs, nil
+}
+
+// release 释放语句，已经被淘汰的语句在没有使用者时关闭。
+func (c *stmtCache) release(cs *cachedStmt) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cs.refs--
+	if cs.evicted && cs.refs == 0 {
+		return cs.stmt.Close()
+	}
+	return nil
+}
+
+// evict 从缓存中移除语句，必须持有锁。
+func (c *stmtCache) evict(e *list.Element) {
+	cs := c.ll.Remove(e).(*cachedStmt)
+	delete(c.items, cs.query)
+	cs.evicted = true
+	if cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
+
+// resize 修改缓存的容量，超出的语句被淘汰，0表示不缓存。
+func (c *stmtCache) resize(capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = max(capacity, 0)
+	for c.ll.Len() > c.capacity {
+		c.evict(c.ll.Back())
+	}
+}
+
+func (c *stmtCache) snapshot() StmtCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.ll.Len()
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// SetStmtCacheSize 设置数据源最多缓存的预编译语句的个数，0表示不缓存，每次执行sql时都重新准备语句。
+// 默认为DefaultStmtCacheSize，同时作用于所有从库。
+func (ds *DataSource) SetStmtCacheSize(size int) {
+	ds.stmts.resize(size)
+	for _, r := range ds.replicaList() {
+		r.stmts.resize(size)
+	}
+}
+
+// StmtCacheStats 获取数据源主库的预编译语句缓存的统计信息。
+func (ds *DataSource) StmtCacheStats() StmtCacheStats { return ds.stmts.snapshot() }
@@ -0,0 +1,48 @@
+package dbhelper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStmtCache(t *testing.T) {
+	ctx := context.TODO()
+	ds := GetDataSource(DefaultDataSource)
+	ds.SetStmtCacheSize(0)
+	ds.SetStmtCacheSize(2)
+	defer ds.SetStmtCacheSize(DefaultStmtCacheSize)
+
+	s0 := ds.StmtCacheStats()
+	MustQuery[int](ctx, "SELECT 1 WHERE 1 = :1", 1)
+	MustQuery[int](ctx, "SELECT 1 WHERE 1 = :1", 2)
+	if s := ds.StmtCacheStats(); s.Hits-s0.Hits != 1 || s.Misses-s0.Misses != 1 {
+		t.Errorf("StmtCacheStats() => %+v, want 1 hit and 1 miss", s)
+	}
+
+	// 事务中通过tx.StmtContext复用缓存的语句。
+	txCtx := BeginTx(ctx, false)
+	MustQuery[int](txCtx, "SELECT 1 WHERE 1 = :1", 3)
+	CommitTx(txCtx)
+	CloseTx(txCtx)
+	if s := ds.StmtCacheStats(); s.Hits-s0.Hits != 2 {
+		t.Errorf("StmtCacheStats() => %+v, want 2 hits", s)
+	}
+
+	MustQuery[int](ctx, "SELECT 2 WHERE 1 = :1", 1)
+	MustQuery[int](ctx, "SELECT 3 WHERE 1 = :1", 1)
+	if s := ds.StmtCacheStats(); s.Size != 2 || s.Evictions-s0.Evictions != 1 {
+		t.Errorf("StmtCacheStats() => %+v, want size 2 and 1 eviction", s)
+	}
+
+	// 被淘汰的语句可以重新准备。
+	if v := MustQuery[int](ctx, "SELECT 1 WHERE 1 = :1", 1); v != 1 {
+		t.Errorf("Query() => %d, want 1", v)
+	}
+
+	ds.SetStmtCacheSize(0)
+	if v := MustQuery[int](ctx, "SELECT 1 WHERE 1 = :1", 1); v != 1 {
+		t.Errorf("Query() => %d, want 1", v)
+	} else if s := ds.StmtCacheStats(); s.Size != 0 {
+		t.Errorf("StmtCacheStats() => %+v, want size 0", s)
+	}
+}
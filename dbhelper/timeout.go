@@ -0,0 +1,67 @@
+package dbhelper
+
+import (
+	"context"
+	"time"
+)
+
+// timeoutCtxKey 用于在上下文中记录单次调用的超时时间的key。
+type timeoutCtxKey struct{}
+
+// WithQueryTimeout 返回一个新的上下文，之后使用该上下文执行的每条sql的超时时间都是d，覆盖数据源的默认超时时间。
+// d小于等于0表示不设置超时时间，此时只受上下文本身的截止时间约束。
+// 超时时间从准备语句开始计算，包括读取结果的时间；对于游标是从打开到关闭的时间。
+// 在PostgreSQL上，如果数据源通过WithStatementTimeout设置了服务端的statement_timeout，那么d不能超过该时间，参见WithStatementTimeout。
+func WithQueryTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutCtxKey{}, d)
+}
+
+// SetStatementTimeout 设置数据源默认的sql超时时间，只对没有截止时间的上下文生效，不适用于游标，小于等于0表示不设置，默认不设置。
+// 超时后sql被取消并返回ErrQueryTimeout。应当在初始化数据源时设置，也可以通过WithStatementTimeout设置，
+// 后者在PostgreSQL上还会设置服务端的statement_timeout，此时游标和更长的WithQueryTimeout也受其限制。
+func (ds *DataSource) SetStatementTimeout(d time.Duration) { ds.stmtTimeout = d }
+
+// StatementTimeout 获取数据源默认的sql超时时间。
+func (ds *DataSource) StatementTimeout() time.Duration { return ds.stmtTimeout }
+
+// queryContext 返回执行单条sql使用的上下文。
+// 优先使用WithQueryTimeout指定的超时时间，其次在上下文没有截止时间时使用数据源默认的超时时间。
+func (ds *DataSource) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	d, ok := ctx.Value(timeoutCtxKey{}).(time.Duration)
+	if !ok {
+		if _, hasDeadline := ctx.Deadline(); hasDeadline {
+			return ctx, func() {}
+		}
+		d = ds.stmtTimeout
+	}
+
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// withoutDefaultTimeout 返回不使用数据源默认超时时间的上下文，WithQueryTimeout指定的超时时间保持不变。
+func withoutDefaultTimeout(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(timeoutCtxKey{}).(time.Duration); ok {
+		return ctx
+	}
+	return WithQueryTimeout(ctx, 0)
+}
+
+// classifyTimeout 将因为上下文超时而被取消的查询的错误转换为ErrQueryTimeout。
+// 超时时各个驱动返回的错误不同：go-sql-driver/mysql返回context.DeadlineExceeded，
+// lib/pq返回取消查询的57014错误，go-sqlite3返回SQLITE_INTERRUPT错误。
+func classifyTimeout(ctx context.Context, err error) error {
+	err = ClassifyError(err)
+	if KindOf(err) != KindQueryCanceled || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	if de, ok := err.(*DbError); ok {
+		de2 := *de
+		de2.Kind = KindQueryTimeout
+		return &de2
+	}
+	return &DbError{Kind: KindQueryTimeout, Err: err}
+}
@@ -0,0 +1,97 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const slowQuery = "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT COUNT(*) FROM c"
+
+func TestQueryTimeout(t *testing.T) {
+	ctx := context.TODO()
+	ds := GetDataSource(DefaultDataSource)
+
+	start := time.Now()
+	if _, err := Query[int64](WithQueryTimeout(ctx, 50*time.Millisecond), slowQuery); !errors.Is(err, ErrQueryTimeout) || !errors.Is(err, ErrQueryCanceled) {
+		t.Errorf("Query(WithQueryTimeout) => %v, want ErrQueryTimeout", err)
+	} else if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Query(WithQueryTimeout) took %v", d)
+	}
+
+	ds.SetStatementTimeout(50 * time.Millisecond)
+	defer ds.SetStatementTimeout(0)
+	if _, err := QueryList[int64](ctx, slowQuery); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("QueryList(default timeout) => %v, want ErrQueryTimeout", err)
+	}
+	// 超时之后连接仍然可用。
+	if n := MustQuery[int](ctx, "SELECT 1"); n != 1 {
+		t.Errorf("Query() => %d, want 1", n)
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := Query[int64](cancelCtx, "SELECT 1"); !errors.Is(err, ErrQueryCanceled) || errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Query(canceled) => %v, want ErrQueryCanceled", err)
+	}
+}
+
+func TestCursorTimeout(t *testing.T) {
+	ctx := context.TODO()
+	ds := GetDataSource(DefaultDataSource)
+	ds.SetStatementTimeout(30 * time.Millisecond)
+	defer ds.SetStatementTimeout(0)
+
+	const rows = "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 3) SELECT x FROM c"
+	type row struct{ X int64 }
+	slow := func(*row) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+
+	// 默认的超时时间不适用于游标。
+	n := 0
+	if err := QueryEach[row](ctx, rows, StructMapper[row]{}, func(r *row) error { n++; return slow(r) }); err != nil || n != 3 {
+		t.Errorf("QueryEach(default timeout) => %v, %d, want nil, 3", err, n)
+	}
+	if err := QueryEach[row](WithQueryTimeout(ctx, 30*time.Millisecond), rows, StructMapper[row]{}, slow); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("QueryEach(WithQueryTimeout) => %v, want ErrQueryTimeout", err)
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	ds := &DataSource{stmtTimeout: time.Second}
+
+	deadline := func(ctx context.Context) time.Duration {
+		ctx, cancel := ds.queryContext(ctx)
+		defer cancel()
+		if d, ok := ctx.Deadline(); ok {
+			return time.Until(d).Round(time.Second)
+		}
+		return 0
+	}
+
+	parent, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	cases := []struct {
+		ctx  context.Context
+		want time.Duration
+	}{
+		{context.TODO(), time.Second},
+		{parent, 10 * time.Second},
+		{WithQueryTimeout(context.TODO(), 3*time.Second), 3 * time.Second},
+		{WithQueryTimeout(context.TODO(), 0), 0},
+		{WithQueryTimeout(parent, 3*time.Second), 3 * time.Second},
+	}
+	for i, c := range cases {
+		if got := deadline(c.ctx); got != c.want {
+			t.Errorf("queryContext(%d) => %v, want %v", i, got, c.want)
+		}
+	}
+
+	o := newOptions([]Option{WithStatementTimeout(1500 * time.Millisecond)})
+	if v := o.postgresParams()["statement_timeout"]; v != "1500" {
+		t.Errorf("postgresParams() => statement_timeout=%s, want 1500", v)
+	}
+}
@@ -0,0 +1,194 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Tuple2 表示两列的记录。
+type Tuple2[A, B any] struct {
+	V1 A
+	V2 B
+}
+
+// Tuple3 表示三列的记录。
+type Tuple3[A, B, C any] struct {
+	V1 A
+	V2 B
+	V3 C
+}
+
+// tuple2Mapper 将记录的前两列映射为Tuple2。
+type tuple2Mapper[A, B any] struct{}
+
+func (m tuple2Mapper[A, B]) Scan(r DbRow) (*Tuple2[A, B], error) {
+	result := &Tuple2[A, B]{}
+	err := r.Scan(scanDest(&result.V1), scanDest(&result.V2))
+	return result, err
+}
+
+// tuple3Mapper 将记录的前三列映射为Tuple3。
+type tuple3Mapper[A, B, C any] struct{}
+
+func (m tuple3Mapper[A, B, C]) Scan(r DbRow) (*Tuple3[A, B, C], error) {
+	result := &Tuple3[A, B, C]{}
+	err := r.Scan(scanDest(&result.V1), scanDest(&result.V2), scanDest(&result.V3))
+	return result, err
+}
+
+// QueryTuple2 查询两列的记录列表，sql必须恰好返回两列，A和B的要求与Query的T相同。
+func QueryTuple2[A, B any](ctx context.Context, query string, args ...any) ([]Tuple2[A, B], error) {
+	list, err := QueryObjList[Tuple2[A, B]](ctx, query, tuple2Mapper[A, B]{}, args...)
+	return derefList(list), err
+}
+
+// QueryTuple3 查询三列的记录列表，sql必须恰好返回三列，A、B和C的要求与Query的T相同。
+func QueryTuple3[A, B, C any](ctx context.Context, query string, args ...any) ([]Tuple3[A, B, C], error) {
+	list, err := QueryObjList[Tuple3[A, B, C]](ctx, query, tuple3Mapper[A, B, C]{}, args...)
+	return derefList(list), err
+}
+
+// QueryMap 查询两列的记录，第一列作为key，第二列作为value，key重复时后面的记录覆盖前面的记录。
+func QueryMap[K comparable, V any](ctx context.Context, query string, args ...any) (map[K]V, error) {
+	list, err := QueryObjList[Tuple2[K, V]](ctx, query, tuple2Mapper[K, V]{}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[K]V, len(list))
+	for _, t := range list {
+		m[t.V1] = t.V2
+	}
+	return m, nil
+}
+
+// QueryGroupMap 查询两列的记录，按照第一列分组，每组的value保持记录的顺序。
+func QueryGroupMap[K comparable, V any](ctx context.Context, query string, args ...any) (map[K][]V, error) {
+	list, err := QueryObjList[Tuple2[K, V]](ctx, query, tuple2Mapper[K, V]{}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[K][]V)
+	for _, t := range list {
+		m[t.V1] = append(m[t.V1], t.V2)
+	}
+	return m, nil
+}
+
+// QueryMaps 查询记录列表，每条记录映射为列名到值的map，列名重复时后面的列覆盖前面的列。
+// 值的类型根据列的数据库类型转换，与驱动无关：
+//   - 整数为int64，MySQL的无符号整数为uint64，浮点数为float64；
+//   - DECIMAL和NUMERIC为string，以免丢失精度；
+//   - BLOB、BINARY、BYTEA等二进制类型，以及驱动无法提供类型的列（例如SQLite的表达式）为[]byte；
+//   - JSON为json.RawMessage，编码为JSON时原样输出；
+//   - 其它的字节数组，例如MySQL返回的字符串，转换为string；
+//   - 驱动返回的其它类型（time.Time、bool等）和NULL保持不变。
+func QueryMaps(ctx context.Context, query string, args ...any) ([]map[string]any, error) {
+	list, err := QueryObjList[map[string]any](ctx, query, &mapMapper{}, args...)
+	return derefList(list), err
+}
+
+// columnTypesRow 表示可以获取列类型的记录，*sql.Rows实现了该接口。
+type columnTypesRow interface {
+	ColumnTypes() ([]*sql.ColumnType, error)
+}
+
+// mapMapper 将记录映射为map，第一次扫描时读取列的类型，因此只能用于一个结果集。
+type mapMapper struct {
+	cols  []string
+	types []string
+}
+
+func (m *mapMapper) Scan(r DbRow) (*map[string]any, error) {
+	if m.cols == nil {
+		cr, ok := r.(columnTypesRow)
+		if !ok {
+			return nil, ErrNoColumns
+		}
+		cts, err := cr.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		m.cols = make([]string, len(cts))
+		m.types = make([]string, len(cts))
+		for i, ct := range cts {
+			m.cols[i], m.types[i] = ct.Name(), strings.ToUpper(ct.DatabaseTypeName())
+		}
+	}
+
+	values := make([]any, len(m.cols))
+	dest := make([]any, len(m.cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := r.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]any, len(m.cols))
+	for i, col := range m.cols {
+		result[col] = convertColumnValue(m.types[i], values[i])
+	}
+	return &result, nil
+}
+
+// convertColumnValue 根据列的数据库类型转换驱动返回的字节数组，其它类型的值原样返回。
+// MySQL的文本协议返回的所有值都是字节数组，lib/pq对NUMERIC、JSON、UUID等类型也返回字节数组。
+// MySQL的二进制协议对FLOAT列返回float32，按照其十进制表示转换为float64，避免出现1.100000023841858这样的值。
+func convertColumnValue(typeName string, v any) any {
+	if f, ok := v.(float32); ok {
+		r, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+		return r
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+
+	unsigned := strings.HasPrefix(typeName, "UNSIGNED ")
+	typeName = strings.TrimPrefix(typeName, "UNSIGNED ")
+	if isIntegerType(typeName) {
+		if unsigned {
+			if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+				return n
+			}
+		} else if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n
+		}
+	} else if typeName == "FLOAT" || typeName == "DOUBLE" || typeName == "REAL" || typeName == "FLOAT4" || typeName == "FLOAT8" {
+		if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return f
+		}
+	} else if typeName == "JSON" || typeName == "JSONB" {
+		return json.RawMessage(b)
+	} else if typeName == "" || typeName == "BYTEA" || typeName == "BIT" || strings.HasSuffix(typeName, "BLOB") || strings.HasSuffix(typeName, "BINARY") {
+		return b
+	}
+	return string(b)
+}
+
+// isIntegerType 判断数据库类型是否为整数类型。
+func isIntegerType(typeName string) bool {
+	switch typeName {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR", "INT2", "INT4", "INT8":
+		return true
+	default:
+		return false
+	}
+}
+
+// derefList 将指针列表转换为值列表。
+func derefList[T any](list []*T) []T {
+	if list == nil {
+		return nil
+	}
+	result := make([]T, len(list))
+	for i, p := range list {
+		result[i] = *p
+	}
+	return result
+}
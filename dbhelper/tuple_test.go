@@ -0,0 +1,79 @@
+package dbhelper
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Lord-Haart/go-common/utils"
+)
+
+func TestQueryTuples(t *testing.T) {
+	ctx := context.TODO()
+	const rows = "SELECT 1 AS k, 'a' AS v, 1.5 AS f UNION ALL SELECT 2, 'b', NULL UNION ALL SELECT 1, 'c', 2.5"
+
+	if list, err := QueryTuple2[int, string](ctx, "SELECT k, v FROM ("+rows+") t"); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 3 || list[1].V1 != 2 || list[1].V2 != "b" {
+		t.Errorf("QueryTuple2() => %+v", list)
+	}
+	if list, err := QueryTuple3[int64, string, utils.String](ctx, "SELECT k, v, f FROM ("+rows+") t"); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 3 || list[0].V3.V != "1.5" || list[1].V3.Valid {
+		t.Errorf("QueryTuple3() => %+v", list)
+	}
+
+	if m, err := QueryMap[int, string](ctx, "SELECT k, v FROM ("+rows+") t"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, map[int]string{1: "c", 2: "b"}) {
+		t.Errorf("QueryMap() => %v", m)
+	}
+	if m, err := QueryGroupMap[int, string](ctx, "SELECT k, v FROM ("+rows+") t"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, map[int][]string{1: {"a", "c"}, 2: {"b"}}) {
+		t.Errorf("QueryGroupMap() => %v", m)
+	}
+	if m, err := QueryKeyValueMap[int](ctx, "SELECT v, k FROM ("+rows+") t"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, map[string]int{"a": 1, "b": 2, "c": 1}) {
+		t.Errorf("QueryKeyValueMap() => %v", m)
+	}
+}
+
+func TestQueryMaps(t *testing.T) {
+	list, err := QueryMaps(context.TODO(), "SELECT 1 AS id, 'a' AS name, 1.5 AS score, NULL AS remark, X'0102' AS data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{{"id": int64(1), "name": "a", "score": 1.5, "remark": nil, "data": []byte{1, 2}}}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("QueryMaps() => %#v, want %#v", list, want)
+	}
+}
+
+func TestConvertColumnValue(t *testing.T) {
+	cases := []struct {
+		typeName string
+		v        any
+		want     any
+	}{
+		{"BIGINT", []byte("-12"), int64(-12)},
+		{"UNSIGNED BIGINT", []byte("18446744073709551615"), uint64(18446744073709551615)},
+		{"DOUBLE", []byte("1.25"), 1.25},
+		{"DECIMAL", []byte("12.30"), "12.30"},
+		{"VARCHAR", []byte("abc"), "abc"},
+		{"JSON", []byte(`{"a":1}`), json.RawMessage(`{"a":1}`)},
+		{"BYTEA", []byte{0, 1}, []byte{0, 1}},
+		{"MEDIUMBLOB", []byte{0, 1}, []byte{0, 1}},
+		{"INT", int64(3), int64(3)},
+		{"FLOAT", float32(1.1), 1.1},
+		{"TEXT", nil, nil},
+	}
+
+	for _, c := range cases {
+		if got := convertColumnValue(c.typeName, c.v); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("convertColumnValue(%s, %v) => %#v, want %#v", c.typeName, c.v, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package dbhelper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Propagation 表示事务的传播方式。
+type Propagation int
+
+const (
+	// PropagationRequired 加入上下文中已有的事务，如果不存在则开始新事务。
+	PropagationRequired Propagation = iota
+	// PropagationRequiresNew 总是开始新事务，上下文中已有的事务对新事务不可见。
+	PropagationRequiresNew
+	// PropagationNested 如果上下文中已有事务则在其中创建保存点，否则开始新事务。
+	PropagationNested
+)
+
+// TxOptions 表示开始事务的选项。
+type TxOptions struct {
+	Propagation Propagation        // 事务的传播方式。
+	Isolation   sql.IsolationLevel // 事务的隔离级别，加入已有事务或者创建保存点时被忽略。
+	ReadOnly    bool               // 是否为只读事务，加入已有事务或者创建保存点时被忽略。
+	Retry       *RetryPolicy       // 重试策略，仅用于WithTx，为nil时使用DefaultRetryPolicy。
+}
+
+// RetryPolicy 表示事务因死锁、锁等待超时或者序列化失败而重试的策略。
+type RetryPolicy struct {
+	MaxAttempts int                             // 最多执行的次数（包括第一次），小于等于1表示不重试。
+	Backoff     func(attempt int) time.Duration // 第attempt次执行失败后等待的时间，为nil时不等待。
+}
+
+var (
+	// ErrTxRollbackOnly 表示内层事务已经回滚，所以外层事务也只能回滚。
+	ErrTxRollbackOnly = errors.New("transaction has been marked as rollback-only")
+	// ErrTxPanic 表示WithTx执行的函数发生了panic。
+	ErrTxPanic = errors.New("panic in transaction")
+
+	// DefaultRetryPolicy 默认的重试策略，最多执行3次，从50毫秒开始指数退避。
+	DefaultRetryPolicy = &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     ExponentialBackoff(50*time.Millisecond, time.Second),
+	}
+)
+
+// ExponentialBackoff 创建指数退避函数，等待时间从base开始每次翻倍，不超过max，并加入随机抖动。
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		// 随机抖动，避免发生冲突的多个事务同时重试。
+		return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+}
+
+// ctxKey 用于在上下文中记录事务对象的key。
+type ctxKey struct{}
+
+// connCtxKey 用于在上下文中记录固定使用的连接的key，之后开始的物理事务都在该连接上执行。
+type connCtxKey struct{}
+
+// pinnedConn 表示固定使用的连接及其所属的数据源。
+type pinnedConn struct {
+	ds   *DataSource
+	conn *sql.Conn
+}
+
+// withConn 返回一个新的上下文，之后使用该上下文开始的数据源ds的物理事务都在conn上执行。
+func withConn(ctx context.Context, ds *DataSource, conn *sql.Conn) context.Context {
+	return context.WithValue(ctx, connCtxKey{}, &pinnedConn{ds: ds, conn: conn})
+}
+
+// beginTx 开始物理事务，上下文中固定了数据源的连接时使用该连接。
+func (ds *DataSource) beginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if pc, ok := ctx.Value(connCtxKey{}).(*pinnedConn); ok && pc.ds == ds {
+		return pc.conn.BeginTx(ctx, opts)
+	}
+	return ds.db.BeginTx(ctx, opts)
+}
+
+// ctxRef 表示上下文中的事务。
+// 加入已有事务或者创建保存点时，新的ctxRef和外层共享同一个*sql.Tx，root指向开始该事务的ctxRef。
+type ctxRef struct {
+	ds           *DataSource
+	tx           *sql.Tx
+	alive        bool
+	root         *ctxRef // 开始物理事务的ctxRef，如果自身开始了物理事务则为nil。
+	savepoint    string  // 保存点名称，仅用于PropagationNested。
+	rollbackOnly bool    // 是否只能回滚，仅用于开始物理事务的ctxRef。
+	seq          int     // 保存点序号，仅用于开始物理事务的ctxRef。
+}
+
+func (cr *ctxRef) isAlive() bool {
+	return cr.alive && (cr.root == nil || cr.root.alive)
+}
+
+// report 报告事务操作的执行日志。
+func (cr *ctxRef) report(ctx context.Context, query string, start time.Time, err error) {
+	reportQuery(ctx, &QueryEvent{
+		DataSource:   cr.ds.name,
+		Query:        query,
+		Duration:     time.Since(start),
+		RowsAffected: -1,
+		Err:          err,
+		InTx:         true,
+	})
+}
+
+// exec 在事务上执行保存点相关的语句并报告日志。
+func (cr *ctxRef) exec(ctx context.Context, query string) error {
+	start := time.Now()
+	// 即使上下文已经取消也要释放或者回滚保存点。
+	_, err := cr.tx.ExecContext(context.WithoutCancel(ctx), query)
+	err = ClassifyError(err)
+	cr.report(ctx, query, start, err)
+	return err
+}
+
+func (cr *ctxRef) Commit(ctx context.Context) error {
+	if !cr.alive {
+		return nil
+	} else if cr.root != nil && !cr.root.alive {
+		cr.alive = false
+		return ErrTxNotAlive
+	}
+
+	if cr.savepoint != "" {
+		if err := cr.exec(ctx, "RELEASE SAVEPOINT "+cr.savepoint); err != nil {
+			return err
+		}
+		cr.alive = false
+		return nil
+	} else if cr.root != nil {
+		// 加入的事务由外层提交。
+		cr.alive = false
+		return nil
+	}
+
+	start := time.Now()
+	if cr.rollbackOnly {
+		err := cr.tx.Rollback()
+		cr.report(ctx, "ROLLBACK", start, err)
+		if err != nil {
+			return err
+		}
+		cr.alive = false
+		return ErrTxRollbackOnly
+	} else if err := cr.tx.Commit(); err != nil {
+		err = ClassifyError(err)
+		cr.report(ctx, "COMMIT", start, err)
+		return err
+	} else {
+		cr.alive = false
+		cr.report(ctx, "COMMIT", start, nil)
+		return nil
+	}
+}
+
+func (cr *ctxRef) Close(ctx context.Context) error {
+	if !cr.alive {
+		return nil
+	} else if cr.root != nil && !cr.root.alive {
+		cr.alive = false
+		return nil
+	}
+
+	if cr.savepoint != "" {
+		if err := cr.exec(ctx, "ROLLBACK TO SAVEPOINT "+cr.savepoint); err != nil {
+			return err
+		}
+		cr.alive = false
+		return nil
+	} else if cr.root != nil {
+		// 加入的事务无法单独回滚，只能让外层事务回滚。
+		cr.alive = false
+		cr.root.rollbackOnly = true
+		return nil
+	}
+
+	start := time.Now()
+	err := cr.tx.Rollback()
+	cr.report(ctx, "ROLLBACK", start, err)
+	if err != nil {
+		return err
+	}
+	cr.alive = false
+	return nil
+}
+
+// BeginTx 在上下文对应的数据源上开始事务，返回包含该事务的上下文。如果开始事务失败则panic。
+// 如果上下文中已有该数据源的事务，那么加入该事务。
+func BeginTx(ctx context.Context, serializable bool) context.Context {
+	if ctx, err := TryBeginTx(ctx, serializable); err != nil {
+		panic(err)
+	} else {
+		return ctx
+	}
+}
+
+// TryBeginTx 在上下文对应的数据源上开始事务，返回包含该事务的上下文。
+// 如果上下文中已有该数据源的事务，那么加入该事务。
+func TryBeginTx(ctx context.Context, serializable bool) (context.Context, error) {
+	isolation := sql.LevelDefault
+	if serializable {
+		isolation = sql.LevelSerializable
+	}
+
+	return BeginTxWithOptions(ctx, &TxOptions{Isolation: isolation})
+}
+
+// BeginTxWithOptions 按照指定的选项开始事务，返回包含该事务的上下文。
+// 无论以何种方式开始事务，都应当使用CommitTx提交、使用CloseTx结束返回的上下文中的事务。
+func BeginTxWithOptions(ctx context.Context, opts *TxOptions) (context.Context, error) {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+
+	ds, outer, err := resolveDataSource(ctx)
+	if err != nil {
+		return ctx, err
+	}
+
+	if outer != nil && opts.Propagation != PropagationRequiresNew {
+		if !outer.isAlive() {
+			return ctx, ErrTxNotAlive
+		}
+
+		root := outer
+		if outer.root != nil {
+			root = outer.root
+		}
+
+		cr := &ctxRef{ds: ds, tx: outer.tx, alive: true, root: root}
+		if opts.Propagation == PropagationNested {
+			root.seq++
+			cr.savepoint = "sp_" + strconv.Itoa(root.seq)
+			if err := cr.exec(ctx, "SAVEPOINT "+cr.savepoint); err != nil {
+				return ctx, err
+			}
+		}
+
+		return context.WithValue(ctx, ctxKey{}, cr), nil
+	}
+
+	start := time.Now()
+	if tx, err := ds.beginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}); err != nil {
+		err = ClassifyError(err)
+		(&ctxRef{ds: ds}).report(ctx, "BEGIN", start, err)
+		return ctx, err
+	} else {
+		cr := &ctxRef{ds: ds, tx: tx, alive: true}
+		cr.report(ctx, "BEGIN", start, nil)
+		return context.WithValue(WithDataSource(ctx, ds.name), ctxKey{}, cr), nil
+	}
+}
+
+// CommitTx 提交上下文中的事务。如果提交失败则panic。
+func CommitTx(ctx context.Context) {
+	if err := TryCommitTx(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// TryCommitTx 提交上下文中的事务，如果上下文中没有事务则什么也不做。
+// 加入已有事务时什么也不做，由外层事务提交；创建保存点时释放该保存点。
+// 如果内层事务已经回滚，那么外层事务会被回滚，并返回ErrTxRollbackOnly。
+func TryCommitTx(ctx context.Context) error {
+	if cr, ok := ctx.Value(ctxKey{}).(*ctxRef); ok {
+		return cr.Commit(ctx)
+	}
+	return nil
+}
+
+// CloseTx 结束上下文中的事务，如果事务尚未提交则回滚。
+// 加入已有事务时会让外层事务只能回滚；创建保存点时回滚到该保存点。
+func CloseTx(ctx context.Context) {
+	if cr, ok := ctx.Value(ctxKey{}).(*ctxRef); ok {
+		cr.Close(ctx)
+	}
+}
+
+// WithTx 在事务中执行函数fn，fn返回nil时提交事务，返回错误或者panic时回滚事务。
+// 如果WithTx开始了新的物理事务，那么在发生死锁、锁等待超时或者序列化失败时会按照重试策略重新执行整个事务；
+// 加入已有事务或者创建保存点时不会重试，因为外层事务通常已经无法继续。
+// fn发生panic时返回包装了ErrTxPanic的错误。
+func WithTx(ctx context.Context, opts *TxOptions, fn func(ctx context.Context) error) error {
+	retry := DefaultRetryPolicy
+	if opts != nil && opts.Retry != nil {
+		retry = opts.Retry
+	}
+
+	for attempt := 1; ; attempt++ {
+		own, err := runTx(ctx, opts, fn)
+		if err == nil || !own || attempt >= retry.MaxAttempts || !isRetryableTxError(err) {
+			return err
+		}
+
+		if retry.Backoff != nil {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(retry.Backoff(attempt)):
+			}
+		}
+	}
+}
+
+// runTx 在事务中执行一次fn，own表示是否开始了新的物理事务。
+func runTx(ctx context.Context, opts *TxOptions, fn func(ctx context.Context) error) (own bool, err error) {
+	txCtx, err := BeginTxWithOptions(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+	own = txCtx.Value(ctxKey{}).(*ctxRef).root == nil
+
+	defer CloseTx(txCtx)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrTxPanic, r)
+		}
+	}()
+
+	if err = fn(txCtx); err != nil {
+		return own, err
+	}
+	return own, TryCommitTx(txCtx)
+}
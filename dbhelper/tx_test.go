@@ -0,0 +1,115 @@
+package dbhelper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func countUser(t *testing.T, ctx context.Context, userName string) int {
+	if c, err := Query[int](ctx, "SELECT COUNT(*) FROM user WHERE user_name = :1", userName); err != nil {
+		t.Fatal(err)
+		return 0
+	} else {
+		return c
+	}
+}
+
+func TestNestedTx(t *testing.T) {
+	ctx := BeginTx(context.TODO(), false)
+	defer CloseTx(ctx)
+
+	MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "nested1")
+
+	// 回滚到保存点不影响外层事务。
+	ctx1, err := BeginTxWithOptions(ctx, &TxOptions{Propagation: PropagationNested})
+	if err != nil {
+		t.Fatal(err)
+	}
+	MustExec[int](ctx1, "INSERT INTO user (user_name) VALUES (:1)", "nested2")
+	CloseTx(ctx1)
+
+	// 释放保存点后由外层事务提交。
+	ctx2, err := BeginTxWithOptions(ctx, &TxOptions{Propagation: PropagationNested})
+	if err != nil {
+		t.Fatal(err)
+	}
+	MustExec[int](ctx2, "INSERT INTO user (user_name) VALUES (:1)", "nested3")
+	CommitTx(ctx2)
+	CloseTx(ctx2)
+
+	CommitTx(ctx)
+
+	ctx0 := context.TODO()
+	if c := countUser(t, ctx0, "nested1"); c != 1 {
+		t.Errorf("count(nested1) => %v, want 1", c)
+	}
+	if c := countUser(t, ctx0, "nested2"); c != 0 {
+		t.Errorf("count(nested2) => %v, want 0", c)
+	}
+	if c := countUser(t, ctx0, "nested3"); c != 1 {
+		t.Errorf("count(nested3) => %v, want 1", c)
+	}
+}
+
+func TestRequiredTx(t *testing.T) {
+	ctx := BeginTx(context.TODO(), false)
+	defer CloseTx(ctx)
+
+	MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "required1")
+
+	// 内层提交不会提交外层事务。
+	ctx1 := BeginTx(ctx, false)
+	MustExec[int](ctx1, "INSERT INTO user (user_name) VALUES (:1)", "required2")
+	CommitTx(ctx1)
+	CloseTx(ctx1)
+
+	// 内层回滚会让外层事务只能回滚。
+	ctx2 := BeginTx(ctx, false)
+	CloseTx(ctx2)
+
+	if err := TryCommitTx(ctx); !errors.Is(err, ErrTxRollbackOnly) {
+		t.Errorf("TryCommitTx => %v, want ErrTxRollbackOnly", err)
+	}
+
+	ctx0 := context.TODO()
+	if c := countUser(t, ctx0, "required1") + countUser(t, ctx0, "required2"); c != 0 {
+		t.Errorf("count(required) => %v, want 0", c)
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.TODO()
+	retry := &RetryPolicy{MaxAttempts: 3}
+
+	attempts := 0
+	err := WithTx(ctx, &TxOptions{Retry: retry}, func(ctx context.Context) error {
+		attempts++
+		MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "withtx1")
+		if attempts < 3 {
+			return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if attempts != 3 {
+		t.Errorf("attempts => %v, want 3", attempts)
+	}
+	if c := countUser(t, ctx, "withtx1"); c != 1 {
+		t.Errorf("count(withtx1) => %v, want 1", c)
+	}
+
+	err = WithTx(ctx, &TxOptions{Retry: retry}, func(ctx context.Context) error {
+		MustExec[int](ctx, "INSERT INTO user (user_name) VALUES (:1)", "withtx2")
+		panic("boom")
+	})
+	if !errors.Is(err, ErrTxPanic) {
+		t.Errorf("WithTx(panic) => %v, want ErrTxPanic", err)
+	}
+	if c := countUser(t, ctx, "withtx2"); c != 0 {
+		t.Errorf("count(withtx2) => %v, want 0", c)
+	}
+}